@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnavailable     = errors.New("message broker is unavailable")
+	ErrInvalidQueue    = errors.New("queue names can't contain spaces or newlines")
+	ErrInvalidResponse = errors.New("unexpected response from the message broker")
+)
+
+// Client talks to the MessageBroker, one short lived connection per command
+// like the email worker does
+type Client struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+func NewClient(addr string) *Client {
+	return &Client{Addr: addr, Timeout: 5 * time.Second}
+}
+
+// FromEnv reads BROKER_ADDR, defaulting to the broker on localhost:9005
+func FromEnv() *Client {
+	addr := os.Getenv("BROKER_ADDR")
+	if addr == "" {
+		addr = "localhost:9005"
+	}
+	return NewClient(addr)
+}
+
+// Publish JSON encodes the payload and pushes it onto the named queue
+func (b *Client) Publish(queue string, payload interface{}) error {
+	if queue == "" || strings.ContainsAny(queue, " \r\n") {
+		return ErrInvalidQueue
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	response, err := b.send("PUSH " + queue + " " + string(data))
+	if err != nil {
+		return err
+	}
+	if response != "ACK" {
+		log.Println("broker:", response)
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+// Pop returns the next message of the queue, ok is false when it is empty
+func (b *Client) Pop(queue string) (message string, ok bool, err error) {
+	if queue == "" || strings.ContainsAny(queue, " \r\n") {
+		return "", false, ErrInvalidQueue
+	}
+	response, err := b.send("POP " + queue)
+	if err != nil {
+		return "", false, err
+	}
+	if response == "EMPTY" {
+		return "", false, nil
+	}
+	return response, true, nil
+}
+
+// Consume polls the queue until ctx is cancelled and hands every message to
+// handle. Messages are handled one at a time, in order.
+func (b *Client) Consume(ctx context.Context, queue string, interval time.Duration, handle func(message string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			message, ok, err := b.Pop(queue)
+			if err != nil {
+				log.Println("broker:", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			handle(message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Client) send(command string) (string, error) {
+	conn, err := net.DialTimeout("tcp", b.Addr, b.Timeout)
+	if err != nil {
+		log.Println(err)
+		return "", ErrUnavailable
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(b.Timeout))
+
+	if _, err = fmt.Fprintln(conn, command); err != nil {
+		log.Println(err)
+		return "", ErrUnavailable
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		log.Println(scanner.Err())
+		return "", ErrUnavailable
+	}
+	return scanner.Text(), nil
+}
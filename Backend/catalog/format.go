@@ -0,0 +1,326 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var (
+	ErrUnknownFormat = errors.New("format must be csv or ndjson")
+	ErrMissingHeader = errors.New("csv header must contain sku, product_name and price")
+)
+
+// Columns is the layout of both formats, the import ignores id, rating and
+// review_count since those are not the importer's to set
+var Columns = []string{"id", "sku", "product_name", "price", "currency", "category", "image", "weight_grams", "stock", "rating", "review_count"}
+
+// Row is one product as it appeared in the file, Line is 1 based and counts the header
+type Row struct {
+	Line         int
+	SKU          string
+	Product_Name string
+	Price        string
+	Currency     string
+	Category     string // category slug
+	Image        string
+	Weight_Grams string
+	Stock        string
+	// Set when the line could not be parsed at all
+	Err error
+}
+
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// ReadRows parses the whole file. A malformed line becomes a row with Err set
+// instead of failing the file, only an unreadable CSV header does that.
+func ReadRows(format string, data []byte) ([]Row, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(data)
+	case FormatNDJSON:
+		return readNDJSON(data)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func readCSV(data []byte) ([]Row, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrMissingHeader
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"sku", "product_name", "price"} {
+		if _, ok := index[required]; !ok {
+			return nil, ErrMissingHeader
+		}
+	}
+
+	rows := make([]Row, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+			}
+			rows = append(rows, Row{Line: line, Err: err})
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		rows = append(rows, Row{
+			Line:         line,
+			SKU:          field("sku"),
+			Product_Name: field("product_name"),
+			Price:        field("price"),
+			Currency:     field("currency"),
+			Category:     field("category"),
+			Image:        field("image"),
+			Weight_Grams: field("weight_grams"),
+			Stock:        field("stock"),
+		})
+	}
+	return rows, nil
+}
+
+func readNDJSON(data []byte) ([]Row, error) {
+	rows := make([]Row, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		// Numbers are kept as typed so prices don't pass through float64
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.UseNumber()
+		var fields map[string]interface{}
+		if err := decoder.Decode(&fields); err != nil {
+			rows = append(rows, Row{Line: line, Err: fmt.Errorf("invalid json: %v", err)})
+			continue
+		}
+		field := func(name string) string {
+			switch value := fields[name].(type) {
+			case string:
+				return strings.TrimSpace(value)
+			case json.Number:
+				return value.String()
+			}
+			return ""
+		}
+		rows = append(rows, Row{
+			Line:         line,
+			SKU:          field("sku"),
+			Product_Name: field("product_name"),
+			Price:        field("price"),
+			Currency:     field("currency"),
+			Category:     field("category"),
+			Image:        field("image"),
+			Weight_Grams: field("weight_grams"),
+			Stock:        field("stock"),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		rows = append(rows, Row{Line: line + 1, Err: err})
+	}
+	return rows, nil
+}
+
+// Product validates the row and turns it into the fields to upsert.
+// categories is keyed by slug.
+func (r Row) Product(categories map[string]models.Category) (*models.Product, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.SKU == "" {
+		return nil, errors.New("sku is required")
+	}
+	if len(r.SKU) > 64 || strings.ContainsAny(r.SKU, " \t") {
+		return nil, errors.New("sku must be at most 64 characters without spaces")
+	}
+	if len(r.Product_Name) < 2 || len(r.Product_Name) > 200 {
+		return nil, errors.New("product_name must be between 2 and 200 characters")
+	}
+
+	currency := strings.ToUpper(r.Currency)
+	if currency == "" {
+		currency = models.DefaultCurrency
+	}
+	price, err := models.ParseMoney(r.Price, currency)
+	if err != nil || price.Amount <= 0 {
+		return nil, fmt.Errorf("invalid price %q", r.Price)
+	}
+
+	sku, name := r.SKU, r.Product_Name
+	product := &models.Product{SKU: &sku, Product_Name: &name, Price: &price}
+
+	if r.Category != "" {
+		category, ok := categories[strings.ToLower(r.Category)]
+		if !ok {
+			return nil, fmt.Errorf("unknown category %q", r.Category)
+		}
+		id := category.Category_ID
+		product.Category_ID = &id
+		product.Category = category.Name
+	}
+
+	if r.Image != "" {
+		// Uploaded images are served from a path on this server
+		parsed, err := url.Parse(r.Image)
+		local := err == nil && parsed.Scheme == "" && strings.HasPrefix(r.Image, "/")
+		remote := err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+		if !local && !remote {
+			return nil, errors.New("image must be an http or https url or an absolute path")
+		}
+		image := r.Image
+		product.Image = &image
+	}
+
+	if r.Weight_Grams != "" {
+		weight, err := strconv.Atoi(r.Weight_Grams)
+		if err != nil || weight < 0 {
+			return nil, errors.New("weight_grams must be a whole number of grams")
+		}
+		product.Weight_Grams = &weight
+	}
+
+	if r.Stock != "" {
+		stock, err := strconv.Atoi(r.Stock)
+		if err != nil || stock < 0 {
+			return nil, errors.New("stock must be a whole number, leave it empty to not track stock")
+		}
+		product.Stock = &stock
+	}
+	return product, nil
+}
+
+// Writer streams products in one of the import formats
+type Writer interface {
+	Write(product models.Product) error
+	Flush() error
+}
+
+// NewWriter returns a writer for the format, categories maps ids to slugs so
+// the output can be imported again
+func NewWriter(format string, w io.Writer, categories map[primitive.ObjectID]string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(Columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{writer: writer, categories: categories}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{encoder: json.NewEncoder(w), categories: categories}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// exportFields is a product flattened into the export columns
+func exportFields(product models.Product, categories map[primitive.ObjectID]string) map[string]string {
+	fields := map[string]string{"id": product.Product_ID.Hex(), "review_count": strconv.Itoa(product.Review_Count)}
+	if product.SKU != nil {
+		fields["sku"] = *product.SKU
+	}
+	if product.Product_Name != nil {
+		fields["product_name"] = *product.Product_Name
+	}
+	if product.Price != nil {
+		fields["price"] = product.Price.Decimal()
+		fields["currency"] = product.Price.Currency
+	}
+	if product.Category_ID != nil {
+		fields["category"] = categories[*product.Category_ID]
+	}
+	if product.Image != nil {
+		fields["image"] = *product.Image
+	}
+	if product.Weight_Grams != nil {
+		fields["weight_grams"] = strconv.Itoa(*product.Weight_Grams)
+	}
+	if product.Stock != nil {
+		fields["stock"] = strconv.Itoa(*product.Stock)
+	}
+	if product.Rating != nil {
+		fields["rating"] = strconv.FormatFloat(*product.Rating, 'f', -1, 64)
+	}
+	return fields
+}
+
+type csvWriter struct {
+	writer     *csv.Writer
+	categories map[primitive.ObjectID]string
+}
+
+func (w *csvWriter) Write(product models.Product) error {
+	fields := exportFields(product, w.categories)
+	record := make([]string, len(Columns))
+	for i, column := range Columns {
+		record[i] = fields[column]
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonWriter struct {
+	encoder    *json.Encoder
+	categories map[primitive.ObjectID]string
+}
+
+func (w *ndjsonWriter) Write(product models.Product) error {
+	fields := exportFields(product, w.categories)
+	for _, column := range Columns {
+		if _, ok := fields[column]; !ok {
+			fields[column] = ""
+		}
+	}
+	return w.encoder.Encode(fields)
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return nil
+}
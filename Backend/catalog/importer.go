@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/broker"
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	ImportQueue = "product_import"
+	// Rows upserted per round trip, progress is reported after each batch
+	batchSize = 200
+	// Upload size limit for a single import file
+	MaxImportBytes = 50 << 20
+)
+
+type importMessage struct {
+	Job_ID primitive.ObjectID `json:"job_id"`
+}
+
+// Importer queues uploaded catalog files on the broker and works through them
+// in the background. The file itself waits in GridFS, the message only
+// carries the job id.
+type Importer struct {
+	products   *mongo.Collection
+	categories *mongo.Collection
+	jobs       *mongo.Collection
+	files      *mongo.Database
+	broker     *broker.Client
+}
+
+func NewImporter(products, categories, jobs *mongo.Collection, files *mongo.Database, brokerClient *broker.Client) *Importer {
+	return &Importer{products: products, categories: categories, jobs: jobs, files: files, broker: brokerClient}
+}
+
+// Enqueue stores the file, records the job and queues it
+func (i *Importer) Enqueue(ctx context.Context, format string, data []byte, userID string) (*models.ImportJob, error) {
+	if format != FormatCSV && format != FormatNDJSON {
+		return nil, ErrUnknownFormat
+	}
+
+	job := models.ImportJob{Job_ID: primitive.NewObjectID(), Format: format, Created_By: userID}
+	if err := database.SaveImportFile(ctx, i.files, job.Job_ID, "import."+format, data); err != nil {
+		return nil, err
+	}
+	if err := database.CreateImportJob(ctx, i.jobs, &job); err != nil {
+		_ = database.DeleteImportFile(ctx, i.files, job.Job_ID)
+		return nil, err
+	}
+
+	// The job stays queued when the broker is down, Run picks it up on the next start
+	if err := i.broker.Publish(ImportQueue, importMessage{Job_ID: job.Job_ID}); err != nil {
+		log.Println("import", job.Job_ID.Hex(), "not queued:", err)
+	}
+	return &job, nil
+}
+
+// Run consumes the import queue until ctx is cancelled. Jobs that were queued
+// while the broker or this process was down, or left running by a crashed
+// worker, are queued again first and then every ImportLease. Claiming a job is
+// atomic so a job that ends up queued twice still runs once.
+func (i *Importer) Run(ctx context.Context) {
+	go i.requeuePending(ctx)
+
+	i.broker.Consume(ctx, ImportQueue, 2*time.Second, func(message string) {
+		var decoded importMessage
+		if err := json.Unmarshal([]byte(message), &decoded); err != nil {
+			log.Println("invalid import message:", message)
+			return
+		}
+		i.Process(ctx, decoded.Job_ID)
+	})
+}
+
+func (i *Importer) requeuePending(ctx context.Context) {
+	ticker := time.NewTicker(database.ImportLease)
+	defer ticker.Stop()
+	for {
+		pending, err := database.PendingImportJobs(ctx, i.jobs)
+		if err != nil {
+			log.Println(err)
+		}
+		for _, id := range pending {
+			if err := i.broker.Publish(ImportQueue, importMessage{Job_ID: id}); err != nil {
+				log.Println("import", id.Hex(), "not requeued:", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Process runs one import job to completion. The job is claimed before its
+// file is read, so a duplicate delivery stops without parsing it again.
+func (i *Importer) Process(ctx context.Context, jobID primitive.ObjectID) {
+	job, err := database.ClaimImportJob(ctx, i.jobs, jobID)
+	if err != nil || job == nil {
+		return
+	}
+
+	data, err := database.LoadImportFile(ctx, i.files, jobID)
+	if err != nil {
+		i.fail(jobID, "the uploaded file could not be read")
+		return
+	}
+	rows, err := ReadRows(job.Format, data)
+	if err != nil {
+		i.fail(jobID, err.Error())
+		return
+	}
+	if err := database.SetImportTotal(ctx, i.jobs, jobID, len(rows)); err != nil {
+		log.Println(err)
+	}
+
+	categoryList, err := database.AllCategories(ctx, i.categories)
+	if err != nil {
+		i.fail(jobID, err.Error())
+		return
+	}
+	categories := make(map[string]models.Category, len(categoryList))
+	for _, category := range categoryList {
+		categories[strings.ToLower(category.Slug)] = category
+	}
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := min(start+batchSize, len(rows))
+		if err := i.importBatch(ctx, jobID, rows[start:end], categories); err != nil {
+			i.fail(jobID, err.Error())
+			return
+		}
+	}
+
+	if err := database.FinishImportJob(ctx, i.jobs, jobID, models.ImportStatusCompleted, ""); err != nil {
+		log.Println(err)
+	}
+	if err := database.DeleteImportFile(ctx, i.files, jobID); err != nil {
+		log.Println(err)
+	}
+}
+
+func (i *Importer) importBatch(ctx context.Context, jobID primitive.ObjectID, rows []Row, categories map[string]models.Category) error {
+	rowErrors := make([]models.ImportRowError, 0)
+	products := make([]models.Product, 0, len(rows))
+	valid := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		product, err := row.Product(categories)
+		if err != nil {
+			rowErrors = append(rowErrors, models.ImportRowError{Row: row.Line, SKU: row.SKU, Error: err.Error()})
+			continue
+		}
+		products = append(products, *product)
+		valid = append(valid, row)
+	}
+
+	created, updated := 0, 0
+	if len(products) > 0 {
+		var failures map[int]error
+		var err error
+		created, updated, failures, err = database.UpsertProductsBySKU(ctx, i.products, products)
+		if err != nil {
+			return err
+		}
+		for index, failure := range failures {
+			rowErrors = append(rowErrors, models.ImportRowError{Row: valid[index].Line, SKU: valid[index].SKU, Error: failure.Error()})
+		}
+	}
+	return database.RecordImportProgress(ctx, i.jobs, jobID, len(rows), created, updated, rowErrors)
+}
+
+func (i *Importer) fail(jobID primitive.ObjectID, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := database.FinishImportJob(ctx, i.jobs, jobID, models.ImportStatusFailed, message); err != nil {
+		log.Println(err)
+	}
+	if err := database.DeleteImportFile(ctx, i.files, jobID); err != nil {
+		log.Println(err)
+	}
+}
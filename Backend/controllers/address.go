@@ -3,195 +3,235 @@ package controllers
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
 	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/postal"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func AddAddress() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid COde"})
-			c.Abort()
-			return 
-		}
+// AddressRequest is the body of POST and PUT /addresses, on PUT omitted fields
+// are kept
+type AddressRequest struct {
+	Label            *string `json:"label" binding:"omitempty,max=40"`
+	Recipient_Name   *string `json:"recipient_name" binding:"omitempty,max=100"`
+	Phone            *string `json:"phone" binding:"omitempty,max=20"`
+	House            *string `json:"house" binding:"omitempty,max=200"`
+	Street           *string `json:"street" binding:"omitempty,max=200"`
+	City             *string `json:"city" binding:"omitempty,max=100"`
+	State            *string `json:"state" binding:"omitempty,max=100"`
+	Pincode          *string `json:"pincode" binding:"omitempty,max=20"`
+	Country          *string `json:"country" binding:"omitempty,max=2"`
+	Default_Shipping *bool   `json:"default_shipping"`
+	Default_Billing  *bool   `json:"default_billing"`
+}
 
-		address, err := primitive.ObjectIDFromHex(user_id)
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+func (r AddressRequest) address() models.Address {
+	trim := func(s *string) *string {
+		if s == nil {
+			return nil
 		}
+		trimmed := strings.TrimSpace(*s)
+		return &trimmed
+	}
+	return models.Address{
+		Label:            trim(r.Label),
+		Recipient_Name:   trim(r.Recipient_Name),
+		Phone:            trim(r.Phone),
+		House:            trim(r.House),
+		Street:           trim(r.Street),
+		City:             trim(r.City),
+		State:            trim(r.State),
+		Pincode:          trim(r.Pincode),
+		Country:          trim(r.Country),
+		Default_Shipping: r.Default_Shipping != nil && *r.Default_Shipping,
+		Default_Billing:  r.Default_Billing != nil && *r.Default_Billing,
+	}
+}
 
-		var addresses models.Address
-		addresses.Address_ID = primitive.NewObjectID()
-		if err := c.ShouldBindJSON(&addresses); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+// changesLocation reports whether the request touches more than the label and
+// the default flags, only then is the address checked again
+func (r AddressRequest) changesLocation() bool {
+	for _, field := range []*string{r.Recipient_Name, r.Phone, r.House, r.Street, r.City, r.State, r.Pincode, r.Country} {
+		if field != nil {
+			return true
 		}
+	}
+	return false
+}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		
-		match_filter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: address}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$address"}}}}
-		group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$address_id"}, {Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}}
-
-		pointcursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{match_filter, unwind, group})
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+// mergeAddress overlays the set fields of changes on the stored address
+func mergeAddress(stored models.Address, changes models.Address) models.Address {
+	merged := stored
+	for _, field := range []struct{ from, to **string }{
+		{&changes.Label, &merged.Label},
+		{&changes.Recipient_Name, &merged.Recipient_Name},
+		{&changes.Phone, &merged.Phone},
+		{&changes.House, &merged.House},
+		{&changes.Street, &merged.Street},
+		{&changes.City, &merged.City},
+		{&changes.State, &merged.State},
+		{&changes.Pincode, &merged.Pincode},
+		{&changes.Country, &merged.Country},
+	} {
+		if *field.from != nil {
+			*field.to = *field.from
 		}
+	}
+	return merged
+}
 
-		var addressinfo []bson.M
-		if err = pointcursor.All(ctx, &addressinfo); err != nil {
-			panic(err)
-		}
+func addressErrorStatus(err error) int {
+	if err == database.ErrTooManyAddresses {
+		return http.StatusConflict
+	}
+	return checkoutErrorStatus(err)
+}
 
-		var size int32
-		for _, address_no := range addressinfo{
-			count := address_no["count"]
-			size = count.(int32)
-		}
-		if size < 2 {
-			filter := bson.D{primitive.E{Key: "_id", Value: address}}
-			update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-			_, err := UserCollection.UpdateOne(ctx, filter, update)
-			if err != nil {
-				println(err)
-			}
-		} else {
-			c.IndentedJSON(400, "Not Allowed")
-		}
-		defer cancel()
-		ctx.Done()
- 	}
+// addressError answers validation errors with the problem of each field
+func addressError(c *gin.Context, err error) {
+	if invalid, ok := err.(*postal.ValidationError); ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error(), "fields": invalid.Fields})
+		return
+	}
+	c.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 }
 
-func EditHomeAddress() gin.HandlerFunc {
+func (app *Application) ListAddresses() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid"})
-			c.Abort()
-			return 
-		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
 
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		addresses, err := app.addresses.List(ctx, c.GetString("uid"))
 		if err != nil {
-			c.IndentedJSON(500, "internal server error :)")
+			c.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
-		var editaddress models.Address
-		if err := c.ShouldBindJSON(&editaddress); err != nil {
+		c.JSON(http.StatusOK, gin.H{"addresses": addresses})
+	}
+}
+
+// AddAddress handles POST /addresses. House, street and pincode are required,
+// city and state can be left for the pincode lookup to fill.
+func (app *Application) AddAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request AddressRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		address := request.address()
+		for _, field := range []*string{address.House, address.Street, address.Pincode} {
+			if field == nil || *field == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "house, street and pincode are required"})
+				return
+			}
+		}
+		if err := app.addressChecker.Check(&address); err != nil {
+			addressError(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		filter := bson.D{
-			{Key: "_id", Value: usert_id},
-		}
-		update := bson.D{
-			{
-				Key: "$set",
-				Value: bson.D{
-					{Key: "address.0.house_name", Value: editaddress.House},
-					{Key: "address.0.street_name", Value: editaddress.Street},
-					{Key: "address.0.city_name", Value: editaddress.City},
-					{Key: "address.0.pin_code", Value: editaddress.Pincode},
-				},
-			},
-		}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+
+		created, err := app.addresses.Add(ctx, c.GetString("uid"), address)
 		if err != nil {
-			c.IndentedJSON(500, "Something Went Wrong")
+			addressError(c, err)
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Updated Address Successfully")
+		c.JSON(http.StatusCreated, created)
 	}
 }
 
-func EditWorkAddress() gin.HandlerFunc {
+// UpdateAddress handles PUT /addresses/:id. A default is moved by setting it on
+// another address, it can't be switched off.
+func (app *Application) UpdateAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid"})
-			c.Abort()
-			return 
-		}
-
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		addressID, err := primitive.ObjectIDFromHex(c.Param("id"))
 		if err != nil {
-			c.IndentedJSON(500, "internal server error :)")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address id"})
+			return
 		}
-		var editaddress models.Address
-		if err := c.ShouldBindJSON(&editaddress); err != nil {
+		var request AddressRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		if (request.Default_Shipping != nil && !*request.Default_Shipping) || (request.Default_Billing != nil && !*request.Default_Billing) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "To change the default, make another address the default"})
+			return
+		}
+		changes := request.address()
+		for _, field := range []*string{changes.House, changes.Street, changes.Pincode} {
+			if field != nil && *field == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "house, street and pincode can't be empty"})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		filter := bson.D{
-			{Key: "_id", Value: usert_id},
-		}
-		update := bson.D{
-			{
-				Key: "$set",
-				Value: bson.D{
-					{Key: "address.1.house_name", Value: editaddress.House},
-					{Key: "address.1.street_name", Value: editaddress.Street},
-					{Key: "address.1.city_name", Value: editaddress.City},
-					{Key: "address.1.pin_code", Value: editaddress.Pincode},
-				},
-			},
-		}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+
+		uid := c.GetString("uid")
+		if request.changesLocation() {
+			stored, err := app.addresses.Find(ctx, uid, addressID)
+			if err != nil {
+				addressError(c, err)
+				return
+			}
+			// The whole address is checked and saved, an emptied city or state is
+			// filled in again from the pincode
+			merged := mergeAddress(*stored, changes)
+			if err = app.addressChecker.Check(&merged); err != nil {
+				addressError(c, err)
+				return
+			}
+			// The flags are what the request asks for, not what is stored
+			merged.Default_Shipping, merged.Default_Billing = changes.Default_Shipping, changes.Default_Billing
+			changes = merged
+		}
+
+		updated, err := app.addresses.Update(ctx, uid, addressID, changes)
 		if err != nil {
-			c.IndentedJSON(500, "Something Went Wrong")
+			c.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Updated Address Successfully")
+		c.JSON(http.StatusOK, updated)
 	}
 }
 
-func DeleteAddress() gin.HandlerFunc {
+func (app *Application) DeleteAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search index"})
-			c.Abort()
-			return 
-		}
-
-		addresses := make([]models.Address, 0)
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		addressID, err := primitive.ObjectIDFromHex(c.Param("id"))
 		if err != nil {
-			c.IndentedJSON(500, "internal server error :)")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid address id"})
+			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
-		filter:= bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.IndentedJSON(404, "Wonggg")
+
+		if err = app.addresses.Delete(ctx, c.GetString("uid"), addressID); err != nil {
+			c.JSON(addressErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Yay! Deleted address")
+		c.JSON(http.StatusOK, gin.H{"message": "Address deleted"})
+	}
+}
+
+// LookupPincode handles /users/pincodes/:code?country=IN so forms can fill in
+// city and state as soon as the pincode is typed
+func (app *Application) LookupPincode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		place, ok := app.addressChecker.Lookup(strings.ToUpper(c.Query("country")), c.Param("code"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown pincode"})
+			return
+		}
+		c.JSON(http.StatusOK, place)
 	}
-}
\ No newline at end of file
+}
@@ -7,23 +7,113 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/Bhanubpsn/e-commerce-backend/catalog"
 	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/media"
 	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/notify"
+	"github.com/Bhanubpsn/e-commerce-backend/payment"
+	"github.com/Bhanubpsn/e-commerce-backend/postal"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
+	"github.com/Bhanubpsn/e-commerce-backend/repository"
+	"github.com/Bhanubpsn/e-commerce-backend/search"
+	"github.com/Bhanubpsn/e-commerce-backend/storage"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+type CartItemRequest struct {
+	Product_ID string `json:"product_id" binding:"required"`
+	Quantity   int    `json:"quantity"`
+}
+
+type OrderRequest struct {
+	Payment_Method string `json:"payment_method"`
+	Coupon_Code    string `json:"coupon_code"`
+	Address_ID     string `json:"address_id"`
+	// When set the single product is bought directly instead of the cart
+	Product_ID string `json:"product_id"`
+	Quantity   int    `json:"quantity"`
+}
+
 type Application struct {
-	prodCollection *mongo.Collection
-	userCollection *mongo.Collection
+	users           repository.UserRepository
+	products        repository.ProductRepository
+	orders          repository.OrderRepository
+	checkout        repository.CheckoutRepository
+	carts           repository.CartRepository
+	guestCarts      repository.GuestCartRepository
+	wishlists       repository.WishlistRepository
+	addresses       repository.AddressRepository
+	coupons         repository.CouponRepository
+	categories      repository.CategoryRepository
+	reviews         repository.ReviewRepository
+	payments        repository.PaymentRepository
+	imports         repository.ImportJobRepository
+	recommendations repository.RecommendationRepository
+	paymentProvider payment.Provider
+	searcher        search.Service
+	suggester       search.Suggester
+	imageStore      storage.Store
+	imageProcessor  media.Processor
+	importer        *catalog.Importer
+	cartReminder    *notify.CartReminder
+	addressChecker  *postal.Checker
+}
+
+// Dependencies is everything the handlers use. Fields are set by name, a
+// handler test only fills in what the handler under test needs.
+type Dependencies struct {
+	Users           repository.UserRepository
+	Products        repository.ProductRepository
+	Orders          repository.OrderRepository
+	Checkout        repository.CheckoutRepository
+	Carts           repository.CartRepository
+	GuestCarts      repository.GuestCartRepository
+	Wishlists       repository.WishlistRepository
+	Addresses       repository.AddressRepository
+	Coupons         repository.CouponRepository
+	Categories      repository.CategoryRepository
+	Reviews         repository.ReviewRepository
+	Payments        repository.PaymentRepository
+	Imports         repository.ImportJobRepository
+	Recommendations repository.RecommendationRepository
+
+	// Verifies payment webhooks, charges and refunds go through the repositories
+	PaymentProvider payment.Provider
+	Searcher        search.Service
+	Suggester       search.Suggester
+	ImageStore      storage.Store
+	ImageProcessor  media.Processor
+	Importer        *catalog.Importer
+	CartReminder    *notify.CartReminder
+	AddressChecker  *postal.Checker
 }
 
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
+func NewApplication(deps Dependencies) *Application {
 	return &Application{
-		prodCollection: prodCollection,
-		userCollection: userCollection,
+		users:           deps.Users,
+		products:        deps.Products,
+		orders:          deps.Orders,
+		checkout:        deps.Checkout,
+		carts:           deps.Carts,
+		guestCarts:      deps.GuestCarts,
+		wishlists:       deps.Wishlists,
+		addresses:       deps.Addresses,
+		coupons:         deps.Coupons,
+		categories:      deps.Categories,
+		reviews:         deps.Reviews,
+		payments:        deps.Payments,
+		imports:         deps.Imports,
+		recommendations: deps.Recommendations,
+		paymentProvider: deps.PaymentProvider,
+		searcher:        deps.Searcher,
+		suggester:       deps.Suggester,
+		imageStore:      deps.ImageStore,
+		imageProcessor:  deps.ImageProcessor,
+		importer:        deps.Importer,
+		cartReminder:    deps.CartReminder,
+		addressChecker:  deps.AddressChecker,
 	}
 }
 
@@ -53,12 +143,119 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err = database.AddProductToCart(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
+		cart, err := app.carts.Add(ctx, productID, userQueryID, 1)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(200, gin.H{"usercart": cart})
+	}
+}
+
+// AddCartItem handles POST /cart/items for the signed in user
+func (app *Application) AddCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request CartItemRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Quantity == 0 {
+			request.Quantity = 1
+		}
+
+		productID, err := primitive.ObjectIDFromHex(request.Product_ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		cart, err := app.carts.Add(ctx, productID, c.GetString("uid"), request.Quantity)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"usercart": cart})
+	}
+}
+
+// DeleteCartItem handles DELETE /cart/items/:id for the signed in user
+func (app *Application) DeleteCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.carts.Remove(ctx, productID, c.GetString("uid"))
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Item removed from cart"})
+	}
+}
+
+// PlaceOrder handles POST /orders. Without a product id the whole cart is checked out.
+func (app *Application) PlaceOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request OrderRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		checkout := database.CheckoutRequest{
+			Payment_Method: request.Payment_Method,
+			Coupon_Code:    request.Coupon_Code,
+			Address_ID:     request.Address_ID,
+		}
+
+		var order *models.Order
+		var err error
+		if request.Product_ID == "" {
+			order, err = app.checkout.BuyCart(ctx, c.GetString("uid"), checkout)
+		} else {
+			productID, parseErr := primitive.ObjectIDFromHex(request.Product_ID)
+			if parseErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+				return
+			}
+			if request.Quantity == 0 {
+				request.Quantity = 1
+			}
+			order, err = app.checkout.BuyNow(ctx, productID, c.GetString("uid"), request.Quantity, checkout)
+		}
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, order)
+	}
+}
+
+// ListOrders handles GET /orders, newest first
+func (app *Application) ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		orders, err := app.orders.ListByUser(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		c.IndentedJSON(200, "Successfully added to cart")
+		c.JSON(http.StatusOK, gin.H{"orders": orders})
 	}
 }
 
@@ -88,7 +285,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
+		err = app.carts.Remove(ctx, productID, userQueryID)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, err)
 			return
@@ -97,7 +294,7 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 	}
 }
 
-func GetItemFromCart() gin.HandlerFunc {
+func (app *Application) GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user_id := c.Query("id")
 
@@ -108,41 +305,55 @@ func GetItemFromCart() gin.HandlerFunc {
 			return
 		}
 
-		usert_id, _ := primitive.ObjectIDFromHex(user_id)
-
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var filledCart models.User
-		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&filledCart)
-
+		cart, err := app.users.Cart(ctx, user_id)
 		if err != nil {
 			log.Println(err)
 			c.IndentedJSON(500, "Not Found!")
 			return
 		}
 
-		filter_match := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: usert_id}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-		pointcursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filter_match, unwind, grouping})
-
+		totals, err := cartTotals(cart)
 		if err != nil {
-			log.Panicln(err)
-		}
-		var listing []bson.M
-		if err = pointcursor.All(ctx, &listing); err != nil {
 			log.Println(err)
 			c.AbortWithStatus(http.StatusInternalServerError)
+			return
 		}
+		c.IndentedJSON(200, gin.H{"total": totals, "usercart": cart})
+	}
+}
 
-		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledCart.UserCart)
+// cartTotals sums the cart per currency, in the order the currencies first appear
+func cartTotals(cart []models.ProductUser) ([]models.Money, error) {
+	totals := make([]models.Money, 0)
+	for _, item := range cart {
+		if item.Price.Currency == "" {
+			continue
+		}
+		quantity := item.Quantity
+		if quantity < 1 {
+			quantity = 1
+		}
+		line, err := item.Price.Mul(int64(quantity))
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for i := range totals {
+			if totals[i].Currency == line.Currency {
+				if totals[i], err = totals[i].Add(line); err != nil {
+					return nil, err
+				}
+				found = true
+			}
+		}
+		if !found {
+			totals = append(totals, line)
 		}
-
-		ctx.Done()
 	}
+	return totals, nil
 }
 
 func (app *Application) BuyFromCart() gin.HandlerFunc {
@@ -152,17 +363,19 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 		if userQueryID == "" {
 			log.Println("No User Found of this id")
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("No User Found!"))
+			return
 		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryID)
+		checkout := database.CheckoutRequest{Payment_Method: c.Query("payment"), Coupon_Code: c.Query("coupon"), Address_ID: c.Query("address")}
+		order, err := app.checkout.BuyCart(ctx, userQueryID, checkout)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		c.IndentedJSON(200, "Successfully placed the order")
+		c.IndentedJSON(200, order)
 	}
 }
 
@@ -192,11 +405,35 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err = database.InstantBuy(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
+		checkout := database.CheckoutRequest{Payment_Method: c.Query("payment"), Coupon_Code: c.Query("coupon"), Address_ID: c.Query("address")}
+		order, err := app.checkout.BuyNow(ctx, productID, userQueryID, 1, checkout)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		c.IndentedJSON(200, "Successfully placed the order")
+		c.IndentedJSON(200, order)
+	}
+}
+
+// checkoutErrorStatus maps checkout failures to the status code the client should see
+func checkoutErrorStatus(err error) int {
+	switch err {
+	case database.ErrInvalidPaymentMethod, database.ErrCartIsEmpty, database.ErrUserIdIsNotValid, database.ErrInvalidQuantity,
+		database.ErrAddressRequired, database.ErrAddressIncomplete, database.ErrInvalidCartToken:
+		return http.StatusBadRequest
+	case database.ErrCouponNotStarted, database.ErrCouponExpired, database.ErrCouponMinCartValue,
+		database.ErrCouponCategory, database.ErrCouponUsageLimit, database.ErrCouponUserLimit:
+		return http.StatusUnprocessableEntity
+	case database.ErrCantFindProoduct, database.ErrCouponNotFound, database.ErrAddressNotFound:
+		return http.StatusNotFound
+	case database.ErrOutOfStock:
+		return http.StatusConflict
+	case database.ErrProductNotForSale:
+		return http.StatusUnprocessableEntity
+	case database.ErrPaymentFailed:
+		return http.StatusPaymentRequired
+	case pricing.ErrNotServiceable, database.ErrNotServiceable:
+		return http.StatusUnprocessableEntity
 	}
+	return http.StatusInternalServerError
 }
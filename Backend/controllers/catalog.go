@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/catalog"
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// importFormat works the format out from the query, the file name or the content type
+func importFormat(c *gin.Context, filename string) string {
+	if format := c.Query("format"); format != "" {
+		return strings.ToLower(format)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return catalog.FormatCSV
+	case ".ndjson", ".jsonl":
+		return catalog.FormatNDJSON
+	}
+	switch c.ContentType() {
+	case "text/csv":
+		return catalog.FormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return catalog.FormatNDJSON
+	}
+	return ""
+}
+
+// ImportProducts handles POST /admin/products/import. The file goes either in
+// the "file" field of a multipart form or as the raw body with a text/csv or
+// application/x-ndjson content type. The import runs in the background, poll
+// the returned job for progress.
+func (app *Application) ImportProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, catalog.MaxImportBytes+1<<20)
+
+		var data []byte
+		var err error
+		filename := ""
+		if strings.HasPrefix(c.ContentType(), "multipart/") {
+			header, formErr := c.FormFile("file")
+			if formErr != nil {
+				err = formErr
+			} else {
+				filename = header.Filename
+				file, openErr := header.Open()
+				if openErr != nil {
+					err = openErr
+				} else {
+					data, err = io.ReadAll(io.LimitReader(file, catalog.MaxImportBytes+1))
+					file.Close()
+				}
+			}
+		} else {
+			data, err = io.ReadAll(c.Request.Body)
+		}
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || len(data) > catalog.MaxImportBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Import file is too large"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the import file"})
+			return
+		}
+		if len(data) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Import file is empty"})
+			return
+		}
+
+		format := importFormat(c, filename)
+		if format != catalog.FormatCSV && format != catalog.FormatNDJSON {
+			c.JSON(http.StatusBadRequest, gin.H{"error": catalog.ErrUnknownFormat.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		job, err := app.importer.Enqueue(ctx, format, data, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, job)
+	}
+}
+
+func (app *Application) ImportStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		job, err := app.imports.FindByID(ctx, jobID)
+		if err == database.ErrImportJobNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// ImportErrors returns the rows that failed as CSV, or as JSON with ?format=json
+func (app *Application) ImportErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		jobID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		job, err := app.imports.FindByID(ctx, jobID)
+		if err == database.ErrImportJobNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.Query("format") == "json" {
+			c.JSON(http.StatusOK, gin.H{"failed": job.Failed_Count, "errors": job.Row_Errors})
+			return
+		}
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="import-`+jobID.Hex()+`-errors.csv"`)
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write([]string{"row", "sku", "error"})
+		for _, rowError := range job.Row_Errors {
+			_ = writer.Write([]string{strconv.Itoa(rowError.Row), rowError.SKU, rowError.Error})
+		}
+		writer.Flush()
+	}
+}
+
+// ExportProducts streams the catalog as /admin/products/export?format=csv|ndjson,
+// the output can be fed back into the import
+func (app *Application) ExportProducts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := strings.ToLower(c.DefaultQuery("format", catalog.FormatCSV))
+		if format != catalog.FormatCSV && format != catalog.FormatNDJSON {
+			c.JSON(http.StatusBadRequest, gin.H{"error": catalog.ErrUnknownFormat.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+		defer cancel()
+
+		categoryList, err := app.categories.All(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		slugs := make(map[primitive.ObjectID]string, len(categoryList))
+		for _, category := range categoryList {
+			slugs[category.Category_ID] = category.Slug
+		}
+
+		c.Header("Content-Type", catalog.ContentType(format))
+		c.Header("Content-Disposition", `attachment; filename="products.`+format+`"`)
+		writer, _ := catalog.NewWriter(format, c.Writer, slugs)
+
+		// Headers are gone once the first row is out, a failure can only cut the stream short
+		count := 0
+		err = app.products.Each(ctx, func(product models.Product) error {
+			if err := writer.Write(product); err != nil {
+				return err
+			}
+			count++
+			if count%500 == 0 {
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+				c.Writer.Flush()
+			}
+			return nil
+		})
+		if flushErr := writer.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			log.Println("export stopped after", count, "products:", err)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CategoryUpdateRequest struct {
+	Name *string `json:"name" validate:"omitempty,min=2,max=60"`
+	Slug string  `json:"slug"`
+	// Left out keeps the parent, "" makes the category a root
+	Parent_ID *string `json:"parent_id"`
+}
+
+type ProductCategoryRequest struct {
+	Category_ID string `json:"category_id" binding:"required"`
+}
+
+func categoryErrorStatus(err error) int {
+	switch err {
+	case database.ErrCategoryNotFound, database.ErrCantFindProoduct:
+		return http.StatusNotFound
+	case database.ErrCategoryExists, database.ErrCategoryInUse:
+		return http.StatusConflict
+	case database.ErrCategoryCycle:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// categoryFilter expands a category_id query parameter into the ids of the
+// category and all of its descendants
+func (app *Application) categoryFilter(ctx context.Context, categoryQueryID string) ([]primitive.ObjectID, error) {
+	if categoryQueryID == "" {
+		return nil, nil
+	}
+	categoryID, err := primitive.ObjectIDFromHex(categoryQueryID)
+	if err != nil {
+		return nil, database.ErrCategoryNotFound
+	}
+	return app.categories.Subtree(ctx, categoryID)
+}
+
+func (app *Application) ListCategories() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tree, err := app.categories.Tree(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, tree)
+	}
+}
+
+func (app *Application) CreateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var category models.Category
+		if err := c.BindJSON(&category); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(&category); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		category.Slug = database.Slugify(category.Slug)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := app.categories.Create(ctx, &category); err != nil {
+			c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, category)
+	}
+}
+
+// UpdateCategory renames or moves a category
+func (app *Application) UpdateCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category id"})
+			return
+		}
+
+		var request CategoryUpdateRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		changes := database.CategoryChanges{Name: request.Name, Slug: database.Slugify(request.Slug)}
+		if request.Parent_ID != nil {
+			changes.Move = true
+			if *request.Parent_ID != "" {
+				parentID, err := primitive.ObjectIDFromHex(*request.Parent_ID)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent id"})
+					return
+				}
+				changes.Parent_ID = &parentID
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		category, err := app.categories.Update(ctx, categoryID, changes)
+		if err != nil {
+			c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, category)
+	}
+}
+
+func (app *Application) DeleteCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err = app.categories.Delete(ctx, categoryID); err != nil {
+			c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Category deleted"})
+	}
+}
+
+// AssignProductCategory handles PUT /admin/products/:id/category
+func (app *Application) AssignProductCategory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var request ProductCategoryRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		categoryID, err := primitive.ObjectIDFromHex(request.Category_ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.categories.AssignProduct(ctx, productID, categoryID)
+		if err != nil {
+			c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Product category updated"})
+	}
+}
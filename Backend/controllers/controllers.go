@@ -2,28 +2,25 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Bhanubpsn/e-commerce-backend/database"
 	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/repository"
+	"github.com/Bhanubpsn/e-commerce-backend/search"
 	generate "github.com/Bhanubpsn/e-commerce-backend/token"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
-var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
 var Validate = validator.New()
 
 func HashPassword(password string) string {
@@ -57,8 +54,7 @@ func SendToBroker(email string, name string) {
 	fmt.Fprintln(conn, payload)
 }
 
-func Signup() gin.HandlerFunc {
-	godotenv.Load()
+func (app *Application) Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
@@ -75,28 +71,27 @@ func Signup() gin.HandlerFunc {
 			return
 		}
 
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
+		email := database.NormalizeEmail(*user.Email)
+		user.Email = &email
+
+		exists, err := app.users.EmailExists(ctx, *user.Email)
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-
-		if count > 0 {
+		if exists {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user email already exists"})
+			return
 		}
 
-		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
-
-		defer cancel()
+		exists, err = app.users.PhoneExists(ctx, *user.Phone)
 		if err != nil {
-			log.Panic(err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-
-		if count > 0 {
+		if exists {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user phone already exists"})
+			return
 		}
 
 		password := HashPassword(*user.Password)
@@ -106,62 +101,91 @@ func Signup() gin.HandlerFunc {
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
-		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
+		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, user.Role)
 		user.Token = &token
 		user.Refresh_Token = &refreshToken
 		user.UserCart = make([]models.ProductUser, 0)
+		user.Wishlist = make([]models.WishlistItem, 0)
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
 
-		_, inserter := UserCollection.InsertOne(ctx, user)
-		if inserter != nil {
+		// The checks above race with concurrent signups, the unique indexes don't
+		err = app.users.Create(ctx, &user)
+		if err == repository.ErrEmailExists || err == repository.ErrPhoneExists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "User did not get created"})
 			return
 		}
-		defer cancel()
+		app.mergeGuestCart(ctx, c, user.User_ID)
 		SendToBroker(*user.Email, *user.First_Name)
 		c.JSON(http.StatusCreated, "Successfully signed in: token: "+token)
 	}
 }
 
-func Login() gin.HandlerFunc {
+func (app *Application) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
 		var user models.User
-		var founduser models.User
 		if err := c.BindJSON(&user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
 
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
-		defer cancel()
-
+		founduser, err := app.users.FindByEmail(ctx, database.NormalizeEmail(*user.Email))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Password Incorret"})
 			return
 		}
 
 		PasswordIsValid, msg := VerifyPassword(*user.Password, *founduser.Password)
-		defer cancel()
-
 		if !PasswordIsValid {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			fmt.Println(msg)
 			return
 		}
 
-		token, refreshToken, _ := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID)
-		defer cancel()
+		token, refreshToken, _ := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID, founduser.Role)
+		if err = app.users.UpdateTokens(ctx, founduser.User_ID, token, refreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		founduser.Token = &token
+		founduser.Refresh_Token = &refreshToken
 
-		generate.UpdateAllTokens(token, refreshToken, founduser.User_ID)
+		if app.mergeGuestCart(ctx, c, founduser.User_ID) {
+			if merged, err := app.users.FindByID(ctx, founduser.User_ID); err == nil {
+				founduser = merged
+			}
+		}
 		c.JSON(http.StatusFound, founduser)
 	}
 }
 
-func ProductViewerAdmin() gin.HandlerFunc {
+// mergeGuestCart folds the cart behind the X-Cart-Token header into the user's
+// cart. A failed merge doesn't fail the signup or login, the guest cart is kept
+// so the next login picks it up.
+func (app *Application) mergeGuestCart(ctx context.Context, c *gin.Context, userID string) bool {
+	cartToken := c.GetHeader(CartTokenHeader)
+	if cartToken == "" {
+		return false
+	}
+	if err := app.guestCarts.Merge(ctx, cartToken, userID); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (app *Application) ProductViewerAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		var products models.Product
@@ -172,9 +196,24 @@ func ProductViewerAdmin() gin.HandlerFunc {
 			return
 		}
 
+		// The category name is copied from the category so search can facet on it
+		if products.Category_ID != nil {
+			category, err := app.categories.FindByID(ctx, *products.Category_ID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			products.Category = category.Name
+		}
+
+		// Ratings only come from approved reviews
+		products.Rating = nil
+		products.Review_Count = 0
+		// Images are attached through the upload endpoint
+		products.Images = nil
+
 		products.Product_ID = primitive.NewObjectID()
-		_, err := ProductCollection.InsertOne(ctx, products)
-		if err != nil {
+		if err := app.products.Create(ctx, &products); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Not addede the product"})
 			return
 		}
@@ -183,94 +222,159 @@ func ProductViewerAdmin() gin.HandlerFunc {
 	}
 }
 
-func SearchProduct() gin.HandlerFunc {
+// SearchProduct lists products a page at a time:
+// /users/productview?sort=price&order=asc&category=phones&min_price=100&max_price=500.50&min_rating=3&limit=20&cursor=...
+func (app *Application) SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var productList []models.Product
+		query, err := parseProductListQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		secondaryOpts := options.Collection().SetReadPreference(readpref.SecondaryPreferred())
-
-		// For secondary reads
-		searchCollection, err := ProductCollection.Clone(secondaryOpts)
+		query.Category_IDs, err = app.categoryFilter(ctx, c.Query("category_id"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Secondary read preference error: " + err.Error()})
+			c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		cursor, err := searchCollection.Find(ctx, bson.D{{}})
+		page, err := app.products.List(ctx, query)
+		if err == database.ErrInvalidCursor || err == database.ErrInvalidSort {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, "Something went wrong")
 			return
 		}
+		c.IndentedJSON(200, page)
+	}
+}
 
-		err = cursor.All(ctx, &productList)
-		if err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
+func parseProductListQuery(c *gin.Context) (database.ProductListQuery, error) {
+	query := database.ProductListQuery{
+		Sort:     c.DefaultQuery("sort", "newest"),
+		Category: c.Query("category"),
+		Cursor:   c.Query("cursor"),
+	}
+
+	// Cheapest first and best rated first are what people expect by default
+	switch c.Query("order") {
+	case "asc":
+		query.Ascending = true
+	case "desc":
+		query.Ascending = false
+	case "":
+		query.Ascending = query.Sort == "price"
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return query, errors.New("limit must be a positive number")
 		}
+		query.Limit = n
+	}
 
-		defer cursor.Close(ctx)
-		if err := cursor.Err(); err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "Invalid")
-			return
+	currency := c.DefaultQuery("currency", models.DefaultCurrency)
+	if minPrice := c.Query("min_price"); minPrice != "" {
+		price, err := models.ParseMoney(minPrice, currency)
+		if err != nil {
+			return query, errors.New("invalid min_price")
 		}
+		query.Min_Price = &price
+	}
+	if maxPrice := c.Query("max_price"); maxPrice != "" {
+		price, err := models.ParseMoney(maxPrice, currency)
+		if err != nil {
+			return query, errors.New("invalid max_price")
+		}
+		query.Max_Price = &price
+	}
 
-		defer cancel()
-		c.IndentedJSON(200, productList)
+	if minRating := c.Query("min_rating"); minRating != "" {
+		rating, err := strconv.ParseFloat(minRating, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			return query, errors.New("min_rating must be between 0 and 5")
+		}
+		query.Min_Rating = &rating
 	}
+	return query, nil
 }
 
-func SearchProductByQuery() gin.HandlerFunc {
+// SearchProductByQuery handles /users/search?name=iphone&category_id=...&sort=rating&limit=20,
+// results come back ranked by relevance together with category and price facets
+func (app *Application) SearchProductByQuery() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var SearchProducts []models.Product
-
-		// Get both parameters from the URL: /users/search?name=iphone&category=electronics
 		nameQuery := c.Query("name")
-		categoryQuery := c.Query("category")
-
 		if nameQuery == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Query name is required"})
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		filter := bson.M{
-			"$text": bson.M{"$search": nameQuery},
+		query := search.Query{Text: nameQuery, Category: c.Query("category"), Sort: c.Query("sort")}
+		if limit := c.Query("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+				return
+			}
+			query.Limit = n
 		}
-		if categoryQuery != "" {
-			filter["category"] = categoryQuery
-		}
-
-		// Pagation: limit to 20 results
-		findOptions := options.Find().SetLimit(20)
 
-		// Use a secondary read preference to distribute read load
-		secondaryRead := options.Collection().SetReadPreference(readpref.SecondaryPreferred())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-		// Hopefully the clone does not make a copy of the entire collection, but just a shallow one
-		secondaryCol, err := ProductCollection.Clone(secondaryRead)
+		var err error
+		query.Category_IDs, err = app.categoryFilter(ctx, c.Query("category_id"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Config error"})
+			c.JSON(categoryErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		cursor, err := secondaryCol.Find(ctx, filter, findOptions)
+		result, err := app.searcher.Search(ctx, query)
+		if err == search.ErrEmptyQuery || err == search.ErrInvalidSort {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
 			return
 		}
-		defer cursor.Close(ctx)
 
-		if err = cursor.All(ctx, &SearchProducts); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Decode error"})
+		// Only searches that found something count towards popularity, and the
+		// tracking must not slow the search down
+		if len(result.Items) > 0 {
+			searched := nameQuery
+			if result.Corrected_Query != "" {
+				searched = result.Corrected_Query
+			}
+			go func() {
+				recordCtx, recordCancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer recordCancel()
+				app.suggester.RecordSearch(recordCtx, searched)
+			}()
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// SearchSuggest handles /users/search/suggest?q=iph, answered from memory
+func (app *Application) SearchSuggest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefix := c.Query("q")
+		if prefix == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Query q is required"})
 			return
 		}
 
-		c.JSON(http.StatusOK, SearchProducts)
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		c.JSON(http.StatusOK, gin.H{"suggestions": app.suggester.Suggest(prefix, limit)})
 	}
 }
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+type CouponRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// ApplyCoupon handles POST /cart/coupon, it only previews the discounted total,
+// the coupon is redeemed when the order is placed
+func (app *Application) ApplyCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request CouponRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		summary, err := app.coupons.Preview(ctx, c.GetString("uid"), request.Code)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, summary)
+	}
+}
+
+func (app *Application) CreateCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var coupon models.Coupon
+		if err := c.BindJSON(&coupon); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := Validate.Struct(&coupon); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if *coupon.Type == models.CouponFixed && (coupon.Amount == nil || coupon.Amount.Amount <= 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fixed coupons need a positive amount"})
+			return
+		}
+		if coupon.Starts_At != nil && coupon.Expires_At != nil && coupon.Expires_At.Before(*coupon.Starts_At) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be after starts_at"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := app.coupons.Create(ctx, &coupon)
+		if err == database.ErrCouponExists {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, coupon)
+	}
+}
+
+func (app *Application) ListCoupons() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		coupons, err := app.coupons.List(ctx)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+			return
+		}
+		c.JSON(http.StatusOK, coupons)
+	}
+}
+
+func (app *Application) DeleteCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := app.coupons.Delete(ctx, c.Param("code"))
+		if err == database.ErrCouponNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Coupon deleted"})
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CartTokenHeader carries the anonymous cart token, the first add to cart hands
+// one out and signup or login with it merges the cart into the account
+const CartTokenHeader = "X-Cart-Token"
+
+func (app *Application) GetGuestCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		cart, err := app.guestCarts.Find(ctx, c.GetHeader(CartTokenHeader))
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cart)
+	}
+}
+
+// AddGuestCartItem handles POST /guest/cart/items, a request without a cart
+// token starts a new cart and gets its token back in the header
+func (app *Application) AddGuestCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request CartItemRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Quantity == 0 {
+			request.Quantity = 1
+		}
+
+		productID, err := primitive.ObjectIDFromHex(request.Product_ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		cartToken := c.GetHeader(CartTokenHeader)
+		if cartToken == "" {
+			cartToken = database.NewGuestCartToken()
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.guestCarts.Add(ctx, productID, cartToken, request.Quantity)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		cart, err := app.guestCarts.Find(ctx, cartToken)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Header(CartTokenHeader, cartToken)
+		c.JSON(http.StatusCreated, cart)
+	}
+}
+
+func (app *Application) DeleteGuestCartItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		cartToken := c.GetHeader(CartTokenHeader)
+		if err = app.guestCarts.Remove(ctx, productID, cartToken); err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		cart, err := app.guestCarts.Find(ctx, cartToken)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, cart)
+	}
+}
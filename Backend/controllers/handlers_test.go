@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/repository"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// testUser has no password, hashing one takes a second
+func testUser(email string, phone string) models.User {
+	id := primitive.NewObjectID()
+	return models.User{
+		ID:         id,
+		User_ID:    id.Hex(),
+		First_Name: strPtr("Asha"),
+		Last_Name:  strPtr("Rao"),
+		Email:      strPtr(email),
+		Phone:      strPtr(phone),
+	}
+}
+
+// serve runs one request through a router holding only the handler, uid is
+// set the way Authentication would
+func serve(handler gin.HandlerFunc, method string, route string, target string, body string, uid string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if uid != "" {
+			c.Set("uid", uid)
+		}
+	})
+	router.Handle(method, route, handler)
+
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSignupRejectsTakenEmailAndPhone(t *testing.T) {
+	existing := testUser("asha@example.com", "9876543210")
+	app := NewApplication(Dependencies{Users: repository.NewMemoryUserRepository(existing)})
+
+	tests := []struct {
+		name  string
+		body  string
+		want  int
+		error string
+	}{
+		{"email in another case", `{"first_name":"Ravi","last_name":"Kumar","password":"password1","email":"ASHA@example.com","phone":"9000000000"}`, http.StatusBadRequest, "user email already exists"},
+		{"phone", `{"first_name":"Ravi","last_name":"Kumar","password":"password1","email":"ravi@example.com","phone":"9876543210"}`, http.StatusBadRequest, "user phone already exists"},
+		{"short password", `{"first_name":"Ravi","last_name":"Kumar","password":"short","email":"ravi@example.com","phone":"9000000000"}`, http.StatusBadRequest, ""},
+		{"not json", `{`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(app.Signup(), http.MethodPost, "/users/signup", "/users/signup", tt.body, "")
+			if response.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.want, response.Body)
+			}
+			if tt.error != "" && !strings.Contains(response.Body.String(), tt.error) {
+				t.Errorf("body = %s, want %q", response.Body, tt.error)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	existing := testUser("asha@example.com", "9876543210")
+	existing.Password = strPtr(HashPassword("correct horse"))
+	users := repository.NewMemoryUserRepository(existing)
+	app := NewApplication(Dependencies{Users: users})
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"correct password", `{"email":"asha@example.com","password":"correct horse"}`, http.StatusFound},
+		{"email in another case", `{"email":"Asha@Example.com","password":"correct horse"}`, http.StatusFound},
+		{"wrong password", `{"email":"asha@example.com","password":"battery staple"}`, http.StatusInternalServerError},
+		{"unknown email", `{"email":"ravi@example.com","password":"correct horse"}`, http.StatusInternalServerError},
+		{"no password", `{"email":"asha@example.com"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(app.Login(), http.MethodPost, "/users/signin", "/users/signin", tt.body, "")
+			if response.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.want, response.Body)
+			}
+			if tt.want != http.StatusFound {
+				return
+			}
+			var user models.User
+			if err := json.Unmarshal(response.Body.Bytes(), &user); err != nil {
+				t.Fatal(err)
+			}
+			if user.Token == nil || *user.Token == "" {
+				t.Error("login returned no token")
+			}
+			stored, err := users.FindByID(t.Context(), existing.User_ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Token == nil || *stored.Token != *user.Token {
+				t.Error("the returned token was not stored")
+			}
+		})
+	}
+}
+
+func TestGetItemFromCartTotalsPerCurrency(t *testing.T) {
+	user := testUser("asha@example.com", "9876543210")
+	user.UserCart = []models.ProductUser{
+		{Product_ID: primitive.NewObjectID(), Price: models.NewMoney(49900, "INR"), Quantity: 2},
+		{Product_ID: primitive.NewObjectID(), Price: models.NewMoney(1500, "USD"), Quantity: 1},
+		{Product_ID: primitive.NewObjectID(), Price: models.NewMoney(100, "INR")},
+		// Not for sale, left out of the totals
+		{Product_ID: primitive.NewObjectID()},
+	}
+	app := NewApplication(Dependencies{Users: repository.NewMemoryUserRepository(user)})
+
+	tests := []struct {
+		name   string
+		id     string
+		want   int
+		totals []models.Money
+	}{
+		{"cart", user.User_ID, http.StatusOK, []models.Money{models.NewMoney(99900, "INR"), models.NewMoney(1500, "USD")}},
+		{"unknown user", primitive.NewObjectID().Hex(), http.StatusInternalServerError, nil},
+		{"no id", "", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(app.GetItemFromCart(), http.MethodGet, "/listcart", "/listcart?id="+tt.id, "", "")
+			if response.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.want, response.Body)
+			}
+			if tt.totals == nil {
+				return
+			}
+			var body struct {
+				Total []models.Money `json:"total"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Total) != len(tt.totals) {
+				t.Fatalf("totals = %v, want %v", body.Total, tt.totals)
+			}
+			for i := range tt.totals {
+				if body.Total[i] != tt.totals[i] {
+					t.Errorf("total %d = %v, want %v", i, body.Total[i], tt.totals[i])
+				}
+			}
+		})
+	}
+}
+
+func TestListOrdersNewestFirst(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	user := testUser("asha@example.com", "9876543210")
+	user.Order_Status = []models.Order{{Order_ID: first}, {Order_ID: second}}
+	users := repository.NewMemoryUserRepository(user)
+	app := NewApplication(Dependencies{Users: users, Orders: repository.NewMemoryOrderRepository(users)})
+
+	response := serve(app.ListOrders(), http.MethodGet, "/orders", "/orders", "", user.User_ID)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", response.Code, response.Body)
+	}
+	var body struct {
+		Orders []struct {
+			Order_ID primitive.ObjectID `json:"Order_ID"`
+		} `json:"orders"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Orders) != 2 || body.Orders[0].Order_ID != second || body.Orders[1].Order_ID != first {
+		t.Errorf("orders = %+v, want %s then %s", body.Orders, second.Hex(), first.Hex())
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   string
+		order  string
+		status string
+		want   int
+	}{
+		{"placed to shipped", models.OrderStatusPlaced, "", models.OrderStatusShipped, http.StatusOK},
+		{"legacy order without a status", "", "", models.OrderStatusDelivered, http.StatusOK},
+		{"shipped to delivered", models.OrderStatusShipped, "", models.OrderStatusDelivered, http.StatusOK},
+		{"delivered back to shipped", models.OrderStatusDelivered, "", models.OrderStatusShipped, http.StatusConflict},
+		{"cancelled to delivered", models.OrderStatusCancelled, "", models.OrderStatusDelivered, http.StatusConflict},
+		{"unknown status", models.OrderStatusPlaced, "", "lost", http.StatusBadRequest},
+		{"unknown order", models.OrderStatusPlaced, primitive.NewObjectID().Hex(), models.OrderStatusShipped, http.StatusNotFound},
+		{"invalid order id", models.OrderStatusPlaced, "nope", models.OrderStatusShipped, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderID := primitive.NewObjectID()
+			user := testUser("asha@example.com", "9876543210")
+			user.Order_Status = []models.Order{{Order_ID: orderID, Status: tt.from}}
+			users := repository.NewMemoryUserRepository(user)
+			orders := repository.NewMemoryOrderRepository(users)
+			app := NewApplication(Dependencies{Users: users, Orders: orders})
+
+			target := tt.order
+			if target == "" {
+				target = orderID.Hex()
+			}
+			body := `{"status":"` + tt.status + `"}`
+			response := serve(app.UpdateOrderStatus(), http.MethodPut, "/admin/orders/:id/status", "/admin/orders/"+target+"/status", body, "")
+			if response.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.want, response.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			listed, err := orders.ListByUser(t.Context(), user.User_ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listed[0].Status != tt.status {
+				t.Errorf("stored status = %q, want %q", listed[0].Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestSearchProductPages(t *testing.T) {
+	products := make([]models.Product, 0)
+	for _, price := range []int64{300, 100, 200} {
+		amount := models.NewMoney(price, "INR")
+		products = append(products, models.Product{Product_ID: primitive.NewObjectID(), Product_Name: strPtr("phone"), Price: &amount})
+	}
+	app := NewApplication(Dependencies{Products: repository.NewMemoryProductRepository(products...)})
+
+	tests := []struct {
+		name   string
+		query  string
+		want   int
+		prices []int64
+		next   string
+	}{
+		{"cheapest first", "sort=price&order=asc", http.StatusOK, []int64{100, 200, 300}, ""},
+		{"first page", "sort=price&order=asc&limit=2", http.StatusOK, []int64{100, 200}, "2"},
+		{"second page", "sort=price&order=asc&limit=2&cursor=2", http.StatusOK, []int64{300}, ""},
+		{"unknown sort", "sort=colour", http.StatusBadRequest, nil, ""},
+		{"bad cursor", "cursor=-1", http.StatusBadRequest, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(app.SearchProduct(), http.MethodGet, "/users/productview", "/users/productview?"+tt.query, "", "")
+			if response.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.want, response.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var page models.ProductPage
+			if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != len(tt.prices) {
+				t.Fatalf("got %d products, want %d", len(page.Items), len(tt.prices))
+			}
+			for i, price := range tt.prices {
+				if page.Items[i].Price.Amount != price {
+					t.Errorf("product %d costs %d, want %d", i, page.Items[i].Price.Amount, price)
+				}
+			}
+			if page.Next_Cursor != tt.next {
+				t.Errorf("next cursor = %q, want %q", page.Next_Cursor, tt.next)
+			}
+		})
+	}
+}
+
+func TestUpdateAddressMovesOnlyTheRequestedDefault(t *testing.T) {
+	home := models.Address{Address_ID: primitive.NewObjectID(), House: strPtr("1"), Default_Shipping: true, Default_Billing: true}
+	work := models.Address{Address_ID: primitive.NewObjectID(), House: strPtr("2")}
+	user := testUser("asha@example.com", "9876543210")
+	user.Address_Details = []models.Address{home, work}
+	users := repository.NewMemoryUserRepository(user)
+	app := NewApplication(Dependencies{Users: users, Addresses: repository.NewMemoryAddressRepository(users)})
+
+	target := "/addresses/" + work.Address_ID.Hex()
+	response := serve(app.UpdateAddress(), http.MethodPut, "/addresses/:id", target, `{"label": "Work", "default_shipping": true}`, user.User_ID)
+	if response.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", response.Code, response.Body)
+	}
+
+	stored, err := users.FindByID(context.Background(), user.User_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := stored.Address_Details
+	if got[1].Label == nil || *got[1].Label != "Work" || !got[1].Default_Shipping || got[1].Default_Billing {
+		t.Errorf("work = %+v, want label Work and the shipping default only", got[1])
+	}
+	if got[0].Default_Shipping || !got[0].Default_Billing {
+		t.Errorf("home = %+v, want the billing default only", got[0])
+	}
+}
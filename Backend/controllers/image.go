@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/media"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxImagesPerUpload = 10
+
+func imageErrorStatus(err error) int {
+	switch err {
+	case media.ErrUnsupportedType:
+		return http.StatusUnsupportedMediaType
+	case media.ErrImageTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case media.ErrInvalidImage:
+		return http.StatusBadRequest
+	case database.ErrCantFindProoduct, database.ErrImageNotFound:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// UploadProductImages handles POST /admin/products/:id/images as multipart/form-data
+// with one or more files in the "images" field. Every file is checked before
+// anything is stored, so a bad file rejects the whole upload.
+func (app *Application) UploadProductImages() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		// Room for the files plus the multipart framing around them
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, app.imageProcessor.Max_Bytes*maxImagesPerUpload+1<<20)
+		form, err := c.MultipartForm()
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload is too large"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart form with an images field"})
+			return
+		}
+		files := form.File["images"]
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No images uploaded"})
+			return
+		}
+		if len(files) > maxImagesPerUpload {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d images can be uploaded at once", maxImagesPerUpload)})
+			return
+		}
+
+		prepared := make([]*media.Image, 0, len(files))
+		for _, header := range files {
+			if header.Size > app.imageProcessor.Max_Bytes {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": header.Filename + ": " + media.ErrImageTooLarge.Error()})
+				return
+			}
+			file, err := header.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read " + header.Filename})
+				return
+			}
+			data, err := io.ReadAll(io.LimitReader(file, app.imageProcessor.Max_Bytes+1))
+			file.Close()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read " + header.Filename})
+				return
+			}
+
+			image, err := app.imageProcessor.Prepare(data)
+			if err != nil {
+				c.JSON(imageErrorStatus(err), gin.H{"error": header.Filename + ": " + err.Error()})
+				return
+			}
+			prepared = append(prepared, image)
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		stored := make([]models.ProductImage, 0, len(prepared))
+		for _, image := range prepared {
+			productImage, err := app.storeImage(ctx, productID, image)
+			if err != nil {
+				app.deleteImageFiles(stored)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			stored = append(stored, *productImage)
+		}
+
+		product, err := app.products.AddImages(ctx, productID, stored)
+		if err != nil {
+			app.deleteImageFiles(stored)
+			c.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, product)
+	}
+}
+
+// storeImage writes the image and its thumbnail under products/<product id>/
+func (app *Application) storeImage(ctx context.Context, productID primitive.ObjectID, image *media.Image) (*models.ProductImage, error) {
+	imageID := primitive.NewObjectID()
+	prefix := "products/" + productID.Hex() + "/" + imageID.Hex()
+	productImage := models.ProductImage{
+		Image_ID:      imageID,
+		Key:           prefix + image.Extension,
+		Thumbnail_Key: prefix + "_thumb" + image.Thumbnail_Extension,
+		Content_Type:  image.Content_Type,
+		Size:          len(image.Data),
+		Width:         image.Width,
+		Height:        image.Height,
+		Uploaded_At:   time.Now(),
+	}
+
+	var err error
+	productImage.URL, err = app.imageStore.Put(ctx, productImage.Key, image.Data, image.Content_Type)
+	if err != nil {
+		return nil, err
+	}
+	productImage.Thumbnail_URL, err = app.imageStore.Put(ctx, productImage.Thumbnail_Key, image.Thumbnail, image.Thumbnail_Content_Type)
+	if err != nil {
+		_ = app.imageStore.Delete(ctx, productImage.Key)
+		return nil, err
+	}
+	return &productImage, nil
+}
+
+// deleteImageFiles is best effort, a leftover file is only wasted space
+func (app *Application) deleteImageFiles(images []models.ProductImage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, image := range images {
+		for _, key := range []string{image.Key, image.Thumbnail_Key} {
+			if err := app.imageStore.Delete(ctx, key); err != nil {
+				log.Println("could not delete", key, err)
+			}
+		}
+	}
+}
+
+func (app *Application) DeleteProductImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+		imageID, err := primitive.ObjectIDFromHex(c.Param("image_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		removed, err := app.products.RemoveImage(ctx, productID, imageID)
+		if err != nil {
+			c.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		app.deleteImageFiles([]models.ProductImage{*removed})
+		c.JSON(http.StatusOK, gin.H{"message": "Image deleted"})
+	}
+}
+
+// SetPrimaryImage handles PUT /admin/products/:id/images/:image_id/primary
+func (app *Application) SetPrimaryImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+		imageID, err := primitive.ObjectIDFromHex(c.Param("image_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		product, err := app.products.SetPrimaryImage(ctx, productID, imageID)
+		if err != nil {
+			c.JSON(imageErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, product)
+	}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentWebhook receives status updates from the payment provider.
+// The signature header is checked before anything is written.
+func (app *Application) PaymentWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		payload, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the payload"})
+			return
+		}
+
+		event, err := app.paymentProvider.VerifyWebhook(payload, c.GetHeader("X-Payment-Signature"))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.payments.ApplyEvent(ctx, event)
+		if err == database.ErrCantFindPayment {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	}
+}
+
+func (app *Application) RefundPayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		intentQueryID := c.Query("id")
+		if intentQueryID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payment id is empty"})
+			return
+		}
+
+		intentID, err := primitive.ObjectIDFromHex(intentQueryID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment id"})
+			return
+		}
+
+		// Without an amount the remaining balance is refunded
+		var amount models.Money
+		if amountQuery := c.Query("amount"); amountQuery != "" {
+			amount, err = models.ParseMoney(amountQuery, c.DefaultQuery("currency", models.DefaultCurrency))
+			if err != nil || amount.Amount <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount"})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		intent, err := app.payments.Refund(ctx, intentID, amount)
+		if err == database.ErrCantFindPayment {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, intent)
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PreferencesRequest struct {
+	Cart_Reminders *bool `json:"cart_reminders" binding:"required"`
+}
+
+// UpdatePreferences handles PUT /users/preferences for the signed in user
+func (app *Application) UpdatePreferences() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request PreferencesRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := app.users.SetCartReminderOptOut(ctx, c.GetString("uid"), !*request.Cart_Reminders)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"cart_reminders": *request.Cart_Reminders})
+	}
+}
+
+// UnsubscribeCartReminders handles the link in the reminder email, the
+// signature stands in for logging in
+func (app *Application) UnsubscribeCartReminders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Query("uid")
+		if userID == "" || !app.cartReminder.VerifyUnsubscribe(userID, c.Query("sig")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid unsubscribe link"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := app.users.SetCartReminderOptOut(ctx, userID, true); err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "You will no longer receive cart reminders"})
+	}
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductDetail handles /users/products/:id, views of signed in users are
+// recorded for their recently viewed list
+func (app *Application) ProductDetail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		product, err := app.products.FindByID(ctx, productID)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// A lost view is not worth failing the page for
+		if uid := c.GetString("uid"); uid != "" {
+			if err = app.recommendations.RecordView(ctx, uid, productID); err != nil {
+				log.Println(err)
+			}
+		}
+		c.JSON(http.StatusOK, product)
+	}
+}
+
+// RecentlyViewed handles /users/me/recently-viewed?limit=20
+func (app *Application) RecentlyViewed() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		products, err := app.recommendations.RecentlyViewed(ctx, c.GetString("uid"), limit)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": products})
+	}
+}
+
+// ProductRecommendations handles /users/products/:id/recommendations?limit=10,
+// what customers who bought the product also bought
+func (app *Application) ProductRecommendations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		products, err := app.recommendations.AlsoBought(ctx, productID, limit)
+		if err != nil {
+			c.JSON(checkoutErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"items": products})
+	}
+}
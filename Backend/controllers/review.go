@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReviewRequest struct {
+	Rating int    `json:"rating" validate:"required,gte=1,lte=5"`
+	Title  string `json:"title" validate:"max=120"`
+	Text   string `json:"text" validate:"max=5000"`
+}
+
+type ModerationRequest struct {
+	Status string `json:"status" binding:"required"`
+	Note   string `json:"note"`
+}
+
+type OrderStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+func reviewErrorStatus(err error) int {
+	switch err {
+	case database.ErrReviewNotFound, database.ErrOrderNotFound:
+		return http.StatusNotFound
+	case database.ErrNotVerifiedPurchase:
+		return http.StatusForbidden
+	case database.ErrReviewExists, database.ErrOrderStatusTransition:
+		return http.StatusConflict
+	case database.ErrInvalidReviewStatus, database.ErrInvalidOrderStatus, database.ErrInvalidCursor, database.ErrUserIdIsNotValid:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// CreateReview handles POST /products/:id/reviews, the review waits for moderation
+func (app *Application) CreateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var request ReviewRequest
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		review := models.Review{
+			Product_ID: productID,
+			User_ID:    c.GetString("uid"),
+			Rating:     request.Rating,
+			Title:      request.Title,
+			Text:       request.Text,
+		}
+		if err = app.reviews.Create(ctx, &review); err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, review)
+	}
+}
+
+// ProductReviews handles /users/products/:id/reviews?limit=20&cursor=..., approved reviews only
+func (app *Application) ProductReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		page, err := app.reviews.List(ctx, database.ReviewQuery{
+			Status:     models.ReviewStatusApproved,
+			Product_ID: &productID,
+			Cursor:     c.Query("cursor"),
+			Limit:      limit,
+		})
+		if err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// ListReviews is the moderation queue, /admin/reviews?status=pending by default
+func (app *Application) ListReviews() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		query := database.ReviewQuery{
+			Status: c.DefaultQuery("status", models.ReviewStatusPending),
+			Cursor: c.Query("cursor"),
+			Limit:  limit,
+		}
+		if productQueryID := c.Query("product_id"); productQueryID != "" {
+			productID, err := primitive.ObjectIDFromHex(productQueryID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+				return
+			}
+			query.Product_ID = &productID
+		}
+
+		page, err := app.reviews.List(ctx, query)
+		if err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+func (app *Application) ModerateReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review id"})
+			return
+		}
+
+		var request ModerationRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		review, err := app.reviews.Moderate(ctx, reviewID, request.Status, request.Note)
+		if err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, review)
+	}
+}
+
+func (app *Application) DeleteReview() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reviewID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err = app.reviews.Delete(ctx, reviewID); err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Review deleted"})
+	}
+}
+
+// UpdateOrderStatus handles PUT /admin/orders/:id/status, a delivered order
+// lets the customer review what was in it
+func (app *Application) UpdateOrderStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order id"})
+			return
+		}
+
+		var request OrderStatusRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err = app.orders.UpdateStatus(ctx, orderID, request.Status); err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Order status updated"})
+	}
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WishlistItemRequest struct {
+	Product_ID string `json:"product_id" binding:"required"`
+}
+
+type MoveToCartRequest struct {
+	Quantity int `json:"quantity"`
+}
+
+func wishlistErrorStatus(err error) int {
+	switch err {
+	case database.ErrNotInWishlist, database.ErrNotInCart:
+		return http.StatusNotFound
+	}
+	return checkoutErrorStatus(err)
+}
+
+func (app *Application) GetWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		wishlist, err := app.wishlists.List(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, wishlist)
+	}
+}
+
+func (app *Application) AddWishlistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request WishlistItemRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		productID, err := primitive.ObjectIDFromHex(request.Product_ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.wishlists.Add(ctx, productID, c.GetString("uid"))
+		if err != nil {
+			c.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"message": "Saved to wishlist"})
+	}
+}
+
+func (app *Application) DeleteWishlistItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.wishlists.Remove(ctx, productID, c.GetString("uid"))
+		if err != nil {
+			c.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Removed from wishlist"})
+	}
+}
+
+// MoveToCart handles POST /wishlist/items/:id/move-to-cart
+func (app *Application) MoveToCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		// The body is optional, without it one item is moved
+		var request MoveToCartRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if request.Quantity == 0 {
+			request.Quantity = 1
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.wishlists.MoveToCart(ctx, productID, c.GetString("uid"), request.Quantity)
+		if err != nil {
+			c.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Moved to cart"})
+	}
+}
+
+// SaveForLater handles POST /cart/items/:id/save-for-later
+func (app *Application) SaveForLater() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err = app.wishlists.SaveForLater(ctx, productID, c.GetString("uid"))
+		if err != nil {
+			c.JSON(wishlistErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Saved for later"})
+	}
+}
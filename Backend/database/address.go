@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const MaxAddresses = 50
+
+const (
+	DefaultShipping = "default_shipping"
+	DefaultBilling  = "default_billing"
+)
+
+var (
+	ErrAddressRequired   = errors.New("add a shipping address before checking out")
+	ErrAddressNotFound   = errors.New("address not found")
+	ErrAddressIncomplete = errors.New("shipping address is missing house, street, city or pincode")
+	ErrTooManyAddresses  = errors.New("the address book is full, delete an address first")
+	ErrCantUpdateAddress = errors.New("cannot update the address book")
+	ErrNotServiceable    = errors.New("we don't ship to this address yet")
+)
+
+// Addresses returns the user's address book
+func Addresses(ctx context.Context, userCollection *mongo.Collection, userID string) ([]models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+	var user models.User
+	findOptions := options.FindOne().SetProjection(bson.M{"address": 1})
+	err = userCollection.FindOne(ctx, bson.M{"_id": id}, findOptions).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserIdIsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	if user.Address_Details == nil {
+		user.Address_Details = make([]models.Address, 0)
+	}
+	return user.Address_Details, nil
+}
+
+// AddAddress adds the address to the book and returns it with its id. The first
+// address becomes the default for shipping and billing, later ones only when asked.
+func AddAddress(ctx context.Context, userCollection *mongo.Collection, userID string, address models.Address) (*models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+	address.Address_ID = primitive.NewObjectID()
+
+	// The flags are set below, so a user never has two defaults even for a moment
+	defaultShipping, defaultBilling := address.Default_Shipping, address.Default_Billing
+	address.Default_Shipping, address.Default_Billing = false, false
+
+	filter := bson.M{"_id": id, "address." + strconv.Itoa(MaxAddresses-1): bson.M{"$exists": false}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"address": address}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		count, err := userCollection.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil || count == 0 {
+			return nil, ErrUserIdIsNotValid
+		}
+		return nil, ErrTooManyAddresses
+	}
+
+	addresses, err := Addresses(ctx, userCollection, userID)
+	if err != nil {
+		return nil, err
+	}
+	requested := map[string]bool{DefaultShipping: defaultShipping, DefaultBilling: defaultBilling}
+	for _, flag := range []string{DefaultShipping, DefaultBilling} {
+		target := address.Address_ID
+		if !requested[flag] {
+			// Books from before the flags keep their first address as the default
+			if defaultAddress(addresses, flag) != nil {
+				continue
+			}
+			target = addresses[0].Address_ID
+		}
+		if err = SetDefaultAddress(ctx, userCollection, userID, target, flag); err != nil {
+			return nil, err
+		}
+	}
+	return FindAddress(ctx, userCollection, userID, address.Address_ID)
+}
+
+// UpdateAddress changes the fields set in changes, nil fields are kept. A true
+// default flag moves that default to the address. Fields and flags change in
+// one update, so a failed update leaves both as they were.
+func UpdateAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID, changes models.Address) (*models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+
+	// The update is a pipeline, $literal keeps a value starting with $ from
+	// being read as a field path
+	target := bson.M{}
+	fields := map[string]*string{
+		"label":          changes.Label,
+		"recipient_name": changes.Recipient_Name,
+		"phone":          changes.Phone,
+		"house":          changes.House,
+		"street":         changes.Street,
+		"city":           changes.City,
+		"state":          changes.State,
+		"pincode":        changes.Pincode,
+		"country":        changes.Country,
+	}
+	for field, value := range fields {
+		if value != nil {
+			target[field] = bson.M{"$literal": *value}
+		}
+	}
+	// The flag belongs to the pincode, it is refreshed whenever the pincode is set
+	if changes.Pincode != nil {
+		target["serviceable"] = changes.Serviceable
+	}
+	// The other addresses lose the defaults this one takes
+	others := bson.M{}
+	flags := map[string]bool{DefaultShipping: changes.Default_Shipping, DefaultBilling: changes.Default_Billing}
+	for flag, set := range flags {
+		if set {
+			target[flag] = true
+			others[flag] = false
+		}
+	}
+	if len(target) > 0 {
+		update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"address": bson.M{"$map": bson.M{
+			"input": "$address",
+			"as":    "a",
+			"in": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$$a._id", addressID}},
+				bson.M{"$mergeObjects": bson.A{"$$a", target}},
+				bson.M{"$mergeObjects": bson.A{"$$a", others}},
+			}},
+		}}}}}}
+		result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id, "address._id": addressID}, update)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantUpdateAddress
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrAddressNotFound
+		}
+	}
+	return FindAddress(ctx, userCollection, userID, addressID)
+}
+
+// SetDefaultAddress makes the address the default for the flag and clears the
+// flag on the others in the same update
+func SetDefaultAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID, flag string) error {
+	if flag != DefaultShipping && flag != DefaultBilling {
+		return ErrCantUpdateAddress
+	}
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"address": bson.M{"$map": bson.M{
+		"input": "$address",
+		"as":    "a",
+		"in": bson.M{"$mergeObjects": bson.A{
+			"$$a",
+			bson.M{flag: bson.M{"$eq": bson.A{"$$a._id", addressID}}},
+		}},
+	}}}}}}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id, "address._id": addressID}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+// DeleteAddress removes the address, when it was a default the first remaining
+// address takes over
+func DeleteAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id, "address._id": addressID}, bson.M{"$pull": bson.M{"address": bson.M{"_id": addressID}}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddress
+	}
+	if result.MatchedCount == 0 {
+		return ErrAddressNotFound
+	}
+
+	addresses, err := Addresses(ctx, userCollection, userID)
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+	for _, flag := range []string{DefaultShipping, DefaultBilling} {
+		if defaultAddress(addresses, flag) == nil {
+			if err = SetDefaultAddress(ctx, userCollection, userID, addresses[0].Address_ID, flag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func FindAddress(ctx context.Context, userCollection *mongo.Collection, userID string, addressID primitive.ObjectID) (*models.Address, error) {
+	addresses, err := Addresses(ctx, userCollection, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addresses {
+		if addresses[i].Address_ID == addressID {
+			return &addresses[i], nil
+		}
+	}
+	return nil, ErrAddressNotFound
+}
+
+// defaultAddress returns the address carrying the flag, nil when none does
+func defaultAddress(addresses []models.Address, flag string) *models.Address {
+	for i := range addresses {
+		if (flag == DefaultShipping && addresses[i].Default_Shipping) || (flag == DefaultBilling && addresses[i].Default_Billing) {
+			return &addresses[i]
+		}
+	}
+	return nil
+}
+
+// ResolveShippingAddress picks the address an order ships to. An empty id falls
+// back to the default shipping address, or the first address for address books
+// from before there were defaults.
+func ResolveShippingAddress(user *models.User, addressID string) (*models.Address, error) {
+	if len(user.Address_Details) == 0 {
+		return nil, ErrAddressRequired
+	}
+
+	address := defaultAddress(user.Address_Details, DefaultShipping)
+	if address == nil {
+		address = &user.Address_Details[0]
+	}
+	if addressID != "" {
+		id, err := primitive.ObjectIDFromHex(addressID)
+		if err != nil {
+			return nil, ErrAddressNotFound
+		}
+		address = nil
+		for i := range user.Address_Details {
+			if user.Address_Details[i].Address_ID == id {
+				address = &user.Address_Details[i]
+				break
+			}
+		}
+		if address == nil {
+			return nil, ErrAddressNotFound
+		}
+	}
+
+	if isBlank(address.House) || isBlank(address.Street) || isBlank(address.City) || isBlank(address.Pincode) {
+		return nil, ErrAddressIncomplete
+	}
+	if address.Serviceable != nil && !*address.Serviceable {
+		return nil, ErrNotServiceable
+	}
+	return snapshotAddress(address), nil
+}
+
+// snapshotAddress deep copies the address so the order never shares pointers
+// with the user's address book
+func snapshotAddress(address *models.Address) *models.Address {
+	snapshot := models.Address{
+		Address_ID:     address.Address_ID,
+		Label:          copyString(address.Label),
+		Recipient_Name: copyString(address.Recipient_Name),
+		Phone:          copyString(address.Phone),
+		House:          copyString(address.House),
+		Street:         copyString(address.Street),
+		City:           copyString(address.City),
+		State:          copyString(address.State),
+		Pincode:        copyString(address.Pincode),
+		Country:        copyString(address.Country),
+	}
+	if address.Serviceable != nil {
+		serviceable := *address.Serviceable
+		snapshot.Serviceable = &serviceable
+	}
+	return &snapshot
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	value := *s
+	return &value
+}
+
+func isBlank(s *string) bool {
+	return s == nil || *s == ""
+}
@@ -5,50 +5,119 @@ import (
 	"errors"
 	"log"
 	"time"
+
 	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/payment"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	ErrCantFindProoduct = errors.New("can't find the product")
+	ErrCantFindProoduct   = errors.New("can't find the product")
 	ErrCantDecodeProducts = errors.New("cant't find the product")
-	ErrUserIdIsNotValid = errors.New("this user is not valid")
-	ErrCantUpdateUser = errors.New("cannot update the user")
+	ErrUserIdIsNotValid   = errors.New("this user is not valid")
+	ErrCantUpdateUser     = errors.New("cannot update the user")
 	ErrCantRemoveItemCart = errors.New("cannot remove item from cart")
-	ErrCantGetItem = errors.New("cannot get item")
-	ErrCantBuyCartItme = errors.New("cannot buy the cart item")
+	ErrCantGetItem        = errors.New("cannot get item")
+	ErrCantBuyCartItme    = errors.New("cannot buy the cart item")
+	ErrCartIsEmpty        = errors.New("cart is empty")
+	ErrInvalidQuantity    = errors.New("quantity must be at least 1")
+	ErrProductNotForSale  = errors.New("this product has no price and can't be bought")
+	ErrOutOfStock         = errors.New("not enough of this product in stock")
 )
 
-func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
-	searchfromdb, err := prodCollection.Find(ctx, bson.M{"_id": productID})
+// cartItem snapshots the product for the cart. inCart is the quantity the cart
+// already holds, stock has to cover it together with the new quantity.
+func cartItem(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, quantity int, inCart int) (*models.ProductUser, error) {
+	var raw bson.Raw
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&raw)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindProoduct
+	}
 	if err != nil {
 		log.Println(err)
-		return ErrCantFindProoduct
+		return nil, ErrCantDecodeProducts
 	}
-	
-	var productCart []models.ProductUser
-	err = searchfromdb.All(ctx, &productCart)
-	if err != nil {
+
+	var product models.Product
+	var item models.ProductUser
+	if err = bson.Unmarshal(raw, &product); err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+	if err = bson.Unmarshal(raw, &item); err != nil {
 		log.Println(err)
-		return ErrCantDecodeProducts
+		return nil, ErrCantDecodeProducts
+	}
+
+	if product.Price == nil || product.Price.Currency == "" || product.Price.IsNegative() {
+		return nil, ErrProductNotForSale
+	}
+	if product.Stock != nil && *product.Stock < inCart+quantity {
+		return nil, ErrOutOfStock
+	}
+	item.Quantity = quantity
+	return &item, nil
+}
+
+// cartQuantity is how many of the product the cart holds
+func cartQuantity(cart []models.ProductUser, productID primitive.ObjectID) int {
+	quantity := 0
+	for _, item := range cart {
+		if item.Product_ID == productID {
+			quantity += item.Quantity
+		}
+	}
+	return quantity
+}
+
+// AddProductToCart adds the product at its current price and returns the cart
+// as it is after the change
+func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, quantity int) ([]models.ProductUser, error) {
+	if quantity <= 0 {
+		return nil, ErrInvalidQuantity
 	}
 
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdIsNotValid
+		return nil, ErrUserIdIsNotValid
 	}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{primitive.E{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{{Key: "$each", Value: productCart}}}}}}
+	cart, err := userCart(ctx, userCollection, id)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	item, err := cartItem(ctx, prodCollection, productID, quantity, cartQuantity(cart, productID))
 	if err != nil {
-		return ErrCantUpdateUser
+		return nil, err
 	}
-	return nil
+
+	if err = mergeCartItem(ctx, userCollection, id, *item, bson.M{"$set": cartTouched()}, false); err != nil {
+		return nil, err
+	}
+	return userCart(ctx, userCollection, id)
+}
+
+func userCart(ctx context.Context, userCollection *mongo.Collection, id primitive.ObjectID) ([]models.ProductUser, error) {
+	var user models.User
+	findOptions := options.FindOne().SetProjection(bson.M{"usercart": 1})
+	err := userCollection.FindOne(ctx, bson.M{"_id": id}, findOptions).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserIdIsNotValid
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	if user.UserCart == nil {
+		user.UserCart = make([]models.ProductUser, 0)
+	}
+	return user.UserCart, nil
 }
 
 func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
@@ -59,116 +128,215 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 	}
 
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}}
+	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}, "$set": cartTouched()}
 
 	_, err = userCollection.UpdateMany(ctx, filter, update)
 	if err != nil {
 		return ErrCantRemoveItemCart
 	}
-	return nil	
+	return nil
 }
 
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error{
-	id, err := primitive.ObjectIDFromHex(userID)
-	if err != nil {
-		log.Println(err)
-		return ErrUserIdIsNotValid
-	}
+// CheckoutRequest carries the choices the buyer made for a single checkout
+type CheckoutRequest struct {
+	Payment_Method string
+	Coupon_Code    string
+	Address_ID     string
+}
 
-	var getcartitems models.User
-	var ordercart models.Order
+// reserveStock takes the ordered quantities off the products' stock. The stock is
+// part of the update filter so two orders can't both take the last one. Products
+// without a stock are not tracked and always match.
+func reserveStock(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) error {
+	reserved := make([]models.ProductUser, 0, len(items))
+	for _, item := range items {
+		quantity := pricing.Quantity(item)
+		filter := bson.M{"_id": item.Product_ID, "stock": bson.M{"$gte": quantity}}
+		result, err := prodCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"stock": -quantity}})
+		if err != nil {
+			log.Println(err)
+			releaseStock(ctx, prodCollection, reserved)
+			return ErrCantBuyCartItme
+		}
+		if result.MatchedCount > 0 {
+			reserved = append(reserved, item)
+			continue
+		}
+		tracked, err := prodCollection.CountDocuments(ctx, bson.M{"_id": item.Product_ID, "stock": bson.M{"$type": "number"}})
+		if err != nil {
+			log.Println(err)
+			releaseStock(ctx, prodCollection, reserved)
+			return ErrCantBuyCartItme
+		}
+		if tracked > 0 {
+			releaseStock(ctx, prodCollection, reserved)
+			return ErrOutOfStock
+		}
+	}
+	return nil
+}
 
-	ordercart.Order_ID = primitive.NewObjectID()
-	ordercart.Ordered_At = time.Now()
-	ordercart.Order_Cart = make([]models.ProductUser, 0)
-	ordercart.Payment_Method.COD = true
+// releaseStock puts the quantities reserveStock took back
+func releaseStock(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) {
+	for _, item := range items {
+		update := bson.M{"$inc": bson.M{"stock": pricing.Quantity(item)}}
+		if _, err := prodCollection.UpdateOne(ctx, bson.M{"_id": item.Product_ID}, update); err != nil {
+			log.Println(err)
+		}
+	}
+}
 
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "$path", Value: "$usercart"}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-	currentresults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-	ctx.Done()
+// placeOrder snapshots the shipping address, prices the order, reserves the stock, redeems the coupon, settles the
+// payment and appends the order to the user's orders. When clearCart is set the cart is emptied in the same update.
+func placeOrder(ctx context.Context, prodCollection, userCollection, paymentCollection, couponCollection, redemptionCollection *mongo.Collection, provider payment.Provider, pricer pricing.Pricer, user *models.User, order *models.Order, request CheckoutRequest, clearCart bool) error {
+	address, err := ResolveShippingAddress(user, request.Address_ID)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	order.Shipping_Address = address
 
-	var getusercart []bson.M
-	if err = currentresults.All(ctx, &getusercart); err != nil {
-		panic(err)
+	var coupon *models.Coupon
+	uses := 0
+	if request.Coupon_Code != "" {
+		coupon, err = FindCoupon(ctx, couponCollection, request.Coupon_Code)
+		if err != nil {
+			return err
+		}
+		if uses, err = CouponUses(ctx, redemptionCollection, coupon.Coupon_ID, user.ID.Hex()); err != nil {
+			return err
+		}
 	}
 
-	var total_price int32
-	for _, user_item := range getusercart {
-		price := user_item["total"]
-		total_price = price.(int32)
+	summary, err := SummarizeCart(order.Order_Cart, coupon, uses, *address.Pincode, pricer, order.Ordered_At)
+	if err != nil {
+		return err
 	}
+	order.Subtotal = summary.Subtotal
+	order.Tax = summary.Tax
+	order.Shipping = summary.Shipping
+	order.Price = summary.Total
 
-	ordercart.Price = int(total_price)
+	if err = reserveStock(ctx, prodCollection, order.Order_Cart); err != nil {
+		return err
+	}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordercart}}}}
-	_, err = userCollection.UpdateMany(ctx, filter, update)
-	if err != nil {
-		log.Println(err)
+	if coupon != nil {
+		if err = RedeemCoupon(ctx, couponCollection, redemptionCollection, coupon, user.ID.Hex(), order.Order_ID); err != nil {
+			releaseStock(ctx, prodCollection, order.Order_Cart)
+			return err
+		}
+		order.Discount = &summary.Discount
+		order.Coupon_Code = coupon.Code
 	}
 
-	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getcartitems)
+	err = settleOrder(ctx, userCollection, paymentCollection, provider, user.ID, order, request.Payment_Method, clearCart)
 	if err != nil {
-		log.Panicln(err)
+		releaseStock(ctx, prodCollection, order.Order_Cart)
+		if coupon != nil {
+			ReleaseCoupon(ctx, couponCollection, redemptionCollection, coupon.Coupon_ID, order.Order_ID)
+		}
 	}
+	return err
+}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getcartitems.UserCart}}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
-	if err != nil {
-		log.Panicln(err)
+func settleOrder(ctx context.Context, userCollection, paymentCollection *mongo.Collection, provider payment.Provider, id primitive.ObjectID, order *models.Order, method string, clearCart bool) error {
+	var intent *models.PaymentIntent
+	switch method {
+	case "", PaymentMethodCOD:
+		order.Payment_Method.COD = true
+	case PaymentMethodDigital:
+		order.Payment_Method.Digital = true
+		// Nothing to charge when a coupon covered the whole order
+		if order.Price.Amount > 0 {
+			var err error
+			intent, err = ChargeOrder(ctx, paymentCollection, provider, order, id.Hex())
+			if err != nil {
+				return err
+			}
+			order.Payment_Method.Intent_ID = &intent.Intent_ID
+		}
+	default:
+		return ErrInvalidPaymentMethod
 	}
 
-	usercart_empty := make([]models.ProductUser, 0)
-	filter3 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update3 := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: usercart_empty}}}}
-	_, err = userCollection.UpdateOne(ctx, filter3, update3)
+	order.Status = models.OrderStatusPlaced
+	update := bson.M{"$push": bson.M{"orders": order}}
+	if clearCart {
+		update["$set"] = bson.M{"usercart": make([]models.ProductUser, 0)}
+	}
+	_, err := userCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
 	if err != nil {
 		log.Println(err)
+		if intent != nil {
+			_, _ = RefundPayment(ctx, paymentCollection, provider, intent.Intent_ID, models.Money{})
+		}
 		return ErrCantBuyCartItme
 	}
-
 	return nil
 }
 
-func InstantBuy(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+func BuyItemFromCart(ctx context.Context, prodCollection, userCollection, paymentCollection, couponCollection, redemptionCollection *mongo.Collection, provider payment.Provider, pricer pricing.Pricer, userID string, request CheckoutRequest) (*models.Order, error) {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdIsNotValid
+		return nil, ErrUserIdIsNotValid
 	}
 
-	var product_details models.ProductUser
-	var orders_detail models.Order
+	var getcartitems models.User
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getcartitems)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
 
-	orders_detail.Order_ID = primitive.NewObjectID()
-	orders_detail.Ordered_At = time.Now()
-	orders_detail.Order_Cart = make([]models.ProductUser, 0)
-	orders_detail.Payment_Method.COD = true
+	if len(getcartitems.UserCart) == 0 {
+		return nil, ErrCartIsEmpty
+	}
 
-	err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(product_details)
+	var ordercart models.Order
+	ordercart.Order_ID = primitive.NewObjectID()
+	ordercart.Ordered_At = time.Now()
+	ordercart.Order_Cart = getcartitems.UserCart
+
+	err = placeOrder(ctx, prodCollection, userCollection, paymentCollection, couponCollection, redemptionCollection, provider, pricer, &getcartitems, &ordercart, request, true)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
-	orders_detail.Price = product_details.Price
+	return &ordercart, nil
+}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orders_detail}}}}
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+func InstantBuy(ctx context.Context, prodCollection, userCollection, paymentCollection, couponCollection, redemptionCollection *mongo.Collection, provider payment.Provider, pricer pricing.Pricer, productID primitive.ObjectID, userID string, quantity int, request CheckoutRequest) (*models.Order, error) {
+	if quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+
+	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
+		return nil, ErrUserIdIsNotValid
 	}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": product_details}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user)
 	if err != nil {
 		log.Println(err)
+		return nil, ErrUserIdIsNotValid
 	}
 
-	return nil
-}
\ No newline at end of file
+	// Checked like an add to cart, the stock only has to cover this order
+	product_details, err := cartItem(ctx, prodCollection, productID, quantity, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders_detail models.Order
+	orders_detail.Order_ID = primitive.NewObjectID()
+	orders_detail.Ordered_At = time.Now()
+	orders_detail.Order_Cart = []models.ProductUser{*product_details}
+
+	err = placeOrder(ctx, prodCollection, userCollection, paymentCollection, couponCollection, redemptionCollection, provider, pricer, &user, &orders_detail, request, false)
+	if err != nil {
+		return nil, err
+	}
+	return &orders_detail, nil
+}
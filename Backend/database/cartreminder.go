@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cartTouched is $set on every cart change. A changed cart is a new abandonment
+// as far as reminders go, so the reminder count starts over.
+func cartTouched() bson.M {
+	return bson.M{"cart_updated_at": time.Now(), "cart_reminders": 0}
+}
+
+// staleCart matches a non empty cart that hasn't changed since before, whose
+// user hasn't been reminded since before either and still has reminders left
+func staleCart(before time.Time, maxReminders int) bson.M {
+	return bson.M{
+		"usercart.0":            bson.M{"$exists": true},
+		"cart_updated_at":       bson.M{"$lte": before},
+		"cart_reminder_opt_out": bson.M{"$ne": true},
+		// $not also matches users from before reminders existed
+		"cart_reminders": bson.M{"$not": bson.M{"$gte": maxReminders}},
+		"$or": bson.A{
+			bson.M{"cart_reminded_at": bson.M{"$exists": false}},
+			bson.M{"cart_reminded_at": bson.M{"$lte": before}},
+		},
+	}
+}
+
+// StaleCarts finds users whose cart is due a reminder
+func StaleCarts(ctx context.Context, userCollection *mongo.Collection, before time.Time, maxReminders int, limit int) ([]models.User, error) {
+	findOptions := options.Find().
+		SetSort(bson.M{"cart_updated_at": 1}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"email": 1, "first_name": 1, "usercart": 1, "cart_updated_at": 1, "cart_reminders": 1, "cart_reminded_at": 1})
+	cursor, err := userCollection.Find(ctx, staleCart(before, maxReminders), findOptions)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]models.User, 0)
+	if err = cursor.All(ctx, &users); err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	return users, nil
+}
+
+// ClaimCartReminder counts a reminder before it is queued. The update only
+// applies while the cart is still the one StaleCarts found and still due, so
+// when several instances sweep at once exactly one of them gets the user.
+// false means another instance did, or the cart changed.
+func ClaimCartReminder(ctx context.Context, userCollection *mongo.Collection, user models.User, before time.Time, maxReminders int) (bool, error) {
+	filter := staleCart(before, maxReminders)
+	filter["_id"] = user.ID
+	filter["cart_updated_at"] = *user.Cart_Updated_At
+	update := bson.M{"$inc": bson.M{"cart_reminders": 1}, "$set": bson.M{"cart_reminded_at": time.Now()}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantUpdateUser
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// ReleaseCartReminder takes back a claimed reminder that couldn't be queued,
+// so the next sweep tries again. A cart changed in the meantime is left alone.
+func ReleaseCartReminder(ctx context.Context, userCollection *mongo.Collection, user models.User) error {
+	update := bson.M{"$inc": bson.M{"cart_reminders": -1}}
+	if user.Cart_Reminded_At != nil {
+		update["$set"] = bson.M{"cart_reminded_at": *user.Cart_Reminded_At}
+	} else {
+		update["$unset"] = bson.M{"cart_reminded_at": ""}
+	}
+	_, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID, "cart_updated_at": *user.Cart_Updated_At}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+func SetCartReminderOptOut(ctx context.Context, userCollection *mongo.Collection, userID string, optOut bool) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"cart_reminder_opt_out": optOut}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserIdIsNotValid
+	}
+	return nil
+}
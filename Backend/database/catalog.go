@@ -0,0 +1,323 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Row errors kept on a job, past this only the failed count goes up
+	MaxImportRowErrors = 10000
+	// A running job renews its lease with every batch, a job whose lease ran out
+	// was left behind by a crashed worker and is claimed again
+	ImportLease      = 10 * time.Minute
+	importFileBucket = "ImportFiles"
+)
+
+var (
+	ErrImportJobNotFound = errors.New("import job not found")
+	ErrCantUpdateImport  = errors.New("cannot update the import job")
+)
+
+// CreateImportJob records a queued job, the caller may pick the id up front
+// to save the file under it first
+func CreateImportJob(ctx context.Context, jobCollection *mongo.Collection, job *models.ImportJob) error {
+	if job.Job_ID.IsZero() {
+		job.Job_ID = primitive.NewObjectID()
+	}
+	job.Status = models.ImportStatusQueued
+	job.Row_Errors = make([]models.ImportRowError, 0)
+	job.Created_At = time.Now()
+
+	_, err := jobCollection.InsertOne(ctx, job)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateImport
+	}
+	return nil
+}
+
+// Uploaded import files wait in GridFS under the job id. Unlike the image
+// store nothing here is publicly served, and every instance can read them.
+func importFiles(ctx context.Context, db *mongo.Database) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(importFileBucket))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = bucket.SetReadDeadline(deadline)
+		_ = bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+func SaveImportFile(ctx context.Context, db *mongo.Database, jobID primitive.ObjectID, filename string, data []byte) error {
+	bucket, err := importFiles(ctx, db)
+	if err == nil {
+		err = bucket.UploadFromStreamWithID(jobID, filename, bytes.NewReader(data))
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateImport
+	}
+	return nil
+}
+
+func LoadImportFile(ctx context.Context, db *mongo.Database, jobID primitive.ObjectID) ([]byte, error) {
+	bucket, err := importFiles(ctx, db)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateImport
+	}
+	var data bytes.Buffer
+	if _, err = bucket.DownloadToStream(jobID, &data); err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateImport
+	}
+	return data.Bytes(), nil
+}
+
+func DeleteImportFile(ctx context.Context, db *mongo.Database, jobID primitive.ObjectID) error {
+	bucket, err := importFiles(ctx, db)
+	if err == nil {
+		err = bucket.DeleteContext(ctx, jobID)
+	}
+	if err != nil && err != gridfs.ErrFileNotFound {
+		log.Println(err)
+		return ErrCantUpdateImport
+	}
+	return nil
+}
+
+func FindImportJob(ctx context.Context, jobCollection *mongo.Collection, id primitive.ObjectID) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := jobCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrImportJobNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateImport
+	}
+	return &job, nil
+}
+
+// claimableImport matches the jobs waiting to run: queued ones and running ones
+// whose worker stopped renewing the lease
+func claimableImport(now time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"status": models.ImportStatusQueued},
+		bson.M{"status": models.ImportStatusRunning, "lease_until": bson.M{"$lt": now}},
+	}}
+}
+
+// PendingImportJobs lists the jobs still waiting to run, oldest first
+func PendingImportJobs(ctx context.Context, jobCollection *mongo.Collection) ([]primitive.ObjectID, error) {
+	findOptions := options.Find().SetSort(bson.M{"_id": 1}).SetProjection(bson.M{"_id": 1})
+	cursor, err := jobCollection.Find(ctx, claimableImport(time.Now()), findOptions)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateImport
+	}
+	defer cursor.Close(ctx)
+
+	var jobs []models.ImportJob
+	if err = cursor.All(ctx, &jobs); err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateImport
+	}
+	ids := make([]primitive.ObjectID, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.Job_ID)
+	}
+	return ids, nil
+}
+
+// ClaimImportJob moves a waiting job to running and leases it. It returns nil
+// without an error when the job is already running, so a message delivered
+// twice runs once. A job reclaimed after a crash starts over, the upserts by
+// SKU make running its rows again harmless.
+func ClaimImportJob(ctx context.Context, jobCollection *mongo.Collection, id primitive.ObjectID) (*models.ImportJob, error) {
+	now := time.Now()
+	filter := claimableImport(now)
+	filter["_id"] = id
+	update := bson.M{"$set": bson.M{
+		"status":         models.ImportStatusRunning,
+		"started_at":     now,
+		"lease_until":    now.Add(ImportLease),
+		"processed_rows": 0,
+		"created":        0,
+		"updated":        0,
+		"failed":         0,
+		"row_errors":     bson.A{},
+	}}
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var job models.ImportJob
+	err := jobCollection.FindOneAndUpdate(ctx, filter, update, findOptions).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateImport
+	}
+	return &job, nil
+}
+
+// SetImportTotal records how many rows the file has once it is parsed
+func SetImportTotal(ctx context.Context, jobCollection *mongo.Collection, id primitive.ObjectID, totalRows int) error {
+	_, err := jobCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"total_rows": totalRows}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateImport
+	}
+	return nil
+}
+
+// RecordImportProgress adds a processed batch to the job counters and renews
+// the lease
+func RecordImportProgress(ctx context.Context, jobCollection *mongo.Collection, id primitive.ObjectID, processed, created, updated int, rowErrors []models.ImportRowError) error {
+	update := bson.M{
+		"$inc": bson.M{
+			"processed_rows": processed,
+			"created":        created,
+			"updated":        updated,
+			"failed":         len(rowErrors),
+		},
+		"$set": bson.M{"lease_until": time.Now().Add(ImportLease)},
+	}
+	if len(rowErrors) > 0 {
+		update["$push"] = bson.M{"row_errors": bson.M{"$each": rowErrors, "$slice": MaxImportRowErrors}}
+	}
+	_, err := jobCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateImport
+	}
+	return nil
+}
+
+func FinishImportJob(ctx context.Context, jobCollection *mongo.Collection, id primitive.ObjectID, status string, message string) error {
+	set := bson.M{"status": status, "finished_at": time.Now()}
+	if message != "" {
+		set["error"] = message
+	}
+	_, err := jobCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateImport
+	}
+	return nil
+}
+
+// UpsertProductsBySKU writes a batch of imported products in one round trip.
+// Only the fields that are set are written, so a partial row leaves the rest of
+// an existing product alone. failures maps a batch index to why it failed.
+func UpsertProductsBySKU(ctx context.Context, prodCollection *mongo.Collection, products []models.Product) (created, updated int, failures map[int]error, err error) {
+	writes := make([]mongo.WriteModel, 0, len(products))
+	for _, product := range products {
+		set := bson.M{}
+		if product.Product_Name != nil {
+			set["product_name"] = product.Product_Name
+			set["name_tokens"] = search.NameTokens(*product.Product_Name)
+		}
+		if product.Price != nil {
+			set["price"] = product.Price
+		}
+		if product.Category_ID != nil {
+			set["category_id"] = product.Category_ID
+			set["category"] = product.Category
+		}
+		if product.Image != nil {
+			set["image"] = product.Image
+		}
+		if product.Weight_Grams != nil {
+			set["weight_grams"] = product.Weight_Grams
+		}
+		if product.Stock != nil {
+			set["stock"] = product.Stock
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"sku": product.SKU}).
+			SetUpdate(bson.M{
+				"$set":         set,
+				"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "rating": nil, "review_count": 0},
+			}).
+			SetUpsert(true))
+	}
+
+	failures = make(map[int]error)
+	result, err := prodCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code == 11000 {
+				failures[writeErr.Index] = errors.New("another product was written with this sku at the same time, retry the row")
+			} else {
+				failures[writeErr.Index] = errors.New(writeErr.Message)
+			}
+		}
+		err = nil
+	}
+	if err != nil {
+		log.Println(err)
+		return 0, 0, nil, ErrCantUpdateProduct
+	}
+	if result != nil {
+		created = int(result.UpsertedCount)
+		updated = int(result.MatchedCount)
+	}
+	return created, updated, failures, nil
+}
+
+// AllCategories loads every category, the importer and exporter translate
+// between category ids and slugs with them
+func AllCategories(ctx context.Context, categoryCollection *mongo.Collection) ([]models.Category, error) {
+	cursor, err := categoryCollection.Find(ctx, bson.M{})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+	defer cursor.Close(ctx)
+
+	categories := make([]models.Category, 0)
+	if err = cursor.All(ctx, &categories); err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+	return categories, nil
+}
+
+// EachProduct streams the whole catalog in _id order without holding it in memory
+func EachProduct(ctx context.Context, prodCollection *mongo.Collection, fn func(product models.Product) error) error {
+	cursor, err := prodCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.Println(err)
+		return ErrCantListItems
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err = cursor.Decode(&product); err != nil {
+			log.Println(err)
+			return ErrCantDecodeProducts
+		}
+		if err = fn(product); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,302 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCategoryNotFound   = errors.New("category not found")
+	ErrCategoryExists     = errors.New("a category with this slug already exists")
+	ErrCategoryCycle      = errors.New("a category can't be moved under itself or its descendants")
+	ErrCategoryInUse      = errors.New("category still has subcategories or products")
+	ErrCantUpdateCategory = errors.New("cannot update the category")
+)
+
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns "Phones & Tablets" into "phones-tablets"
+func Slugify(name string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func FindCategory(ctx context.Context, categoryCollection *mongo.Collection, id primitive.ObjectID) (*models.Category, error) {
+	var category models.Category
+	err := categoryCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+	return &category, nil
+}
+
+// ancestorsOf is the ancestor list a child of parent gets
+func ancestorsOf(ctx context.Context, categoryCollection *mongo.Collection, parentID *primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if parentID == nil {
+		return make([]primitive.ObjectID, 0), nil
+	}
+	parent, err := FindCategory(ctx, categoryCollection, *parentID)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]primitive.ObjectID{}, parent.Ancestors...), parent.Category_ID), nil
+}
+
+func CreateCategory(ctx context.Context, categoryCollection *mongo.Collection, category *models.Category) error {
+	ancestors, err := ancestorsOf(ctx, categoryCollection, category.Parent_ID)
+	if err != nil {
+		return err
+	}
+
+	category.Category_ID = primitive.NewObjectID()
+	category.Ancestors = ancestors
+	if category.Slug == "" {
+		category.Slug = Slugify(*category.Name)
+	}
+	// A name without latin letters or digits leaves nothing to slug, the id keeps
+	// those categories from colliding on the empty slug
+	if category.Slug == "" {
+		category.Slug = "category-" + category.Category_ID.Hex()
+	}
+	category.Created_At = time.Now()
+	category.Updated_At = time.Now()
+
+	_, err = categoryCollection.InsertOne(ctx, category)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCategoryExists
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	return nil
+}
+
+// CategoryChanges lists what UpdateCategory should change, zero values are left alone.
+// Set Move with a nil Parent_ID to turn the category into a root.
+type CategoryChanges struct {
+	Name      *string
+	Slug      string
+	Move      bool
+	Parent_ID *primitive.ObjectID
+}
+
+// UpdateCategory renames and/or moves a category. Moving rewrites the ancestor
+// list of the whole subtree, renaming refreshes the name stored on its products.
+func UpdateCategory(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, id primitive.ObjectID, changes CategoryChanges) (*models.Category, error) {
+	category, err := FindCategory(ctx, categoryCollection, id)
+	if err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if changes.Name != nil && *changes.Name != *category.Name {
+		category.Name = changes.Name
+		set["name"] = *changes.Name
+	}
+	if changes.Slug != "" && changes.Slug != category.Slug {
+		category.Slug = changes.Slug
+		set["slug"] = changes.Slug
+	}
+
+	moved := changes.Move && !sameParent(changes.Parent_ID, category.Parent_ID)
+	if moved {
+		if changes.Parent_ID != nil && *changes.Parent_ID == id {
+			return nil, ErrCategoryCycle
+		}
+		ancestors, err := ancestorsOf(ctx, categoryCollection, changes.Parent_ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ancestor := range ancestors {
+			if ancestor == id {
+				return nil, ErrCategoryCycle
+			}
+		}
+		category.Parent_ID = changes.Parent_ID
+		category.Ancestors = ancestors
+		set["parent_id"] = changes.Parent_ID
+		set["ancestors"] = ancestors
+	}
+
+	_, err = categoryCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrCategoryExists
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+
+	if moved {
+		if err = reparentDescendants(ctx, categoryCollection, category); err != nil {
+			return nil, err
+		}
+	}
+	if _, renamed := set["name"]; renamed {
+		_, err = prodCollection.UpdateMany(ctx, bson.M{"category_id": id}, bson.M{"$set": bson.M{"category": *category.Name}})
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantUpdateCategory
+		}
+	}
+	return category, nil
+}
+
+func sameParent(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// reparentDescendants rewrites everything above the moved category in the
+// ancestor lists of its subtree
+func reparentDescendants(ctx context.Context, categoryCollection *mongo.Collection, moved *models.Category) error {
+	cursor, err := categoryCollection.Find(ctx, bson.M{"ancestors": moved.Category_ID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	defer cursor.Close(ctx)
+
+	var descendants []models.Category
+	if err = cursor.All(ctx, &descendants); err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+
+	for _, descendant := range descendants {
+		ancestors := append(append([]primitive.ObjectID{}, moved.Ancestors...), moved.Category_ID)
+		for i, ancestor := range descendant.Ancestors {
+			if ancestor == moved.Category_ID {
+				ancestors = append(ancestors, descendant.Ancestors[i+1:]...)
+				break
+			}
+		}
+		_, err = categoryCollection.UpdateOne(ctx, bson.M{"_id": descendant.Category_ID}, bson.M{"$set": bson.M{"ancestors": ancestors}})
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateCategory
+		}
+	}
+	return nil
+}
+
+func DeleteCategory(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, id primitive.ObjectID) error {
+	children, err := categoryCollection.CountDocuments(ctx, bson.M{"parent_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	products, err := prodCollection.CountDocuments(ctx, bson.M{"category_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if children > 0 || products > 0 {
+		return ErrCategoryInUse
+	}
+
+	result, err := categoryCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if result.DeletedCount == 0 {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// CategoryAndDescendants returns the id of the category and of everything below it,
+// which is what listing and search filter products on
+func CategoryAndDescendants(ctx context.Context, categoryCollection *mongo.Collection, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if _, err := FindCategory(ctx, categoryCollection, id); err != nil {
+		return nil, err
+	}
+
+	cursor, err := categoryCollection.Find(ctx, bson.M{"ancestors": id}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+	defer cursor.Close(ctx)
+
+	ids := []primitive.ObjectID{id}
+	for cursor.Next(ctx) {
+		var descendant struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err = cursor.Decode(&descendant); err == nil {
+			ids = append(ids, descendant.ID)
+		}
+	}
+	return ids, cursor.Err()
+}
+
+// CategoryTree returns every category nested under its parent
+func CategoryTree(ctx context.Context, categoryCollection *mongo.Collection) ([]*models.CategoryNode, error) {
+	cursor, err := categoryCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+	defer cursor.Close(ctx)
+
+	var categories []models.Category
+	if err = cursor.All(ctx, &categories); err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateCategory
+	}
+
+	nodes := make(map[primitive.ObjectID]*models.CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.Category_ID] = &models.CategoryNode{Category: category, Children: make([]*models.CategoryNode, 0)}
+	}
+	roots := make([]*models.CategoryNode, 0)
+	for _, category := range categories {
+		node := nodes[category.Category_ID]
+		if category.Parent_ID != nil {
+			if parent, ok := nodes[*category.Parent_ID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+	return roots, nil
+}
+
+// AssignProductCategory files the product under the category, the category name
+// is copied onto the product for search and facets
+func AssignProductCategory(ctx context.Context, categoryCollection, prodCollection *mongo.Collection, productID, categoryID primitive.ObjectID) error {
+	category, err := FindCategory(ctx, categoryCollection, categoryID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"category_id": categoryID, "category": *category.Name}}
+	result, err := prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCategory
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindProoduct
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCouponNotFound     = errors.New("coupon code is not valid")
+	ErrCouponNotStarted   = errors.New("coupon is not active yet")
+	ErrCouponExpired      = errors.New("coupon has expired")
+	ErrCouponMinCartValue = errors.New("cart value is below the coupon minimum")
+	ErrCouponCategory     = errors.New("coupon does not apply to any item in the cart")
+	ErrCouponUsageLimit   = errors.New("coupon usage limit reached")
+	ErrCouponUserLimit    = errors.New("you have already used this coupon")
+	ErrCouponExists       = errors.New("coupon code already exists")
+	ErrCantUpdateCoupon   = errors.New("cannot update the coupon")
+)
+
+// Codes are matched case-insensitively by always storing them upper-cased
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func FindCoupon(ctx context.Context, couponCollection *mongo.Collection, code string) (*models.Coupon, error) {
+	var coupon models.Coupon
+	err := couponCollection.FindOne(ctx, bson.M{"code": NormalizeCouponCode(code)}).Decode(&coupon)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCouponNotFound
+	}
+	return &coupon, nil
+}
+
+func CreateCoupon(ctx context.Context, couponCollection *mongo.Collection, coupon *models.Coupon) error {
+	code := NormalizeCouponCode(*coupon.Code)
+	coupon.Code = &code
+	coupon.Coupon_ID = primitive.NewObjectID()
+	coupon.Used_Count = 0
+	coupon.Created_At = time.Now()
+	if coupon.Categories == nil {
+		coupon.Categories = make([]string, 0)
+	}
+
+	_, err := couponCollection.InsertOne(ctx, coupon)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrCouponExists
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCoupon
+	}
+	return nil
+}
+
+func ListCoupons(ctx context.Context, couponCollection *mongo.Collection) ([]models.Coupon, error) {
+	// Coupons from before redemptions had their own collection still carry them
+	findOptions := options.Find().SetSort(bson.M{"created_at": -1}).SetProjection(bson.M{"redemptions": 0})
+	cursor, err := couponCollection.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	defer cursor.Close(ctx)
+
+	coupons := make([]models.Coupon, 0)
+	if err = cursor.All(ctx, &coupons); err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	return coupons, nil
+}
+
+func DeleteCoupon(ctx context.Context, couponCollection *mongo.Collection, code string) error {
+	result, err := couponCollection.DeleteOne(ctx, bson.M{"code": NormalizeCouponCode(code)})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCoupon
+	}
+	if result.DeletedCount == 0 {
+		return ErrCouponNotFound
+	}
+	return nil
+}
+
+// CouponUses counts how often the user has redeemed the coupon
+func CouponUses(ctx context.Context, redemptionCollection *mongo.Collection, couponID primitive.ObjectID, userID string) (int, error) {
+	count, err := redemptionCollection.CountDocuments(ctx, bson.M{"coupon_id": couponID, "user_id": userID})
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantUpdateCoupon
+	}
+	return int(count), nil
+}
+
+// CouponDiscount checks the coupon rules against the cart and returns the discount
+// it is worth. userUses is how often the user already redeemed it. Nothing is
+// recorded, RedeemCoupon does that at order creation.
+func CouponDiscount(coupon *models.Coupon, items []models.ProductUser, userUses int, now time.Time) (models.Money, error) {
+	if coupon.Starts_At != nil && now.Before(*coupon.Starts_At) {
+		return models.Money{}, ErrCouponNotStarted
+	}
+	if coupon.Expires_At != nil && now.After(*coupon.Expires_At) {
+		return models.Money{}, ErrCouponExpired
+	}
+	if coupon.Usage_Limit > 0 && coupon.Used_Count >= coupon.Usage_Limit {
+		return models.Money{}, ErrCouponUsageLimit
+	}
+	if coupon.Per_User_Limit > 0 && userUses >= coupon.Per_User_Limit {
+		return models.Money{}, ErrCouponUserLimit
+	}
+
+	subtotal, err := pricing.Subtotal(items)
+	if err != nil {
+		return models.Money{}, err
+	}
+	if coupon.Min_Cart_Value != nil {
+		cmp, err := subtotal.Cmp(*coupon.Min_Cart_Value)
+		if err != nil {
+			return models.Money{}, err
+		}
+		if cmp < 0 {
+			return models.Money{}, ErrCouponMinCartValue
+		}
+	}
+
+	var eligible models.Money
+	for _, item := range items {
+		if !CouponCovers(coupon, item) {
+			continue
+		}
+		line, err := pricing.LineTotal(item)
+		if err != nil {
+			return models.Money{}, err
+		}
+		if eligible, err = eligible.Add(line); err != nil {
+			return models.Money{}, err
+		}
+	}
+	if eligible.IsZero() {
+		return models.Money{}, ErrCouponCategory
+	}
+
+	switch *coupon.Type {
+	case models.CouponPercentage:
+		return eligible.MulDiv(int64(coupon.Percent), 100)
+	case models.CouponFixed:
+		if coupon.Amount == nil {
+			return models.Money{}, nil
+		}
+		return coupon.Amount.Min(eligible)
+	}
+	// Free shipping coupons don't touch the item prices
+	return models.Money{Currency: subtotal.Currency}, nil
+}
+
+// CouponCovers reports whether the coupon applies to the cart item
+func CouponCovers(coupon *models.Coupon, item models.ProductUser) bool {
+	if len(coupon.Categories) == 0 {
+		return true
+	}
+	if item.Category == nil {
+		return false
+	}
+	for _, allowed := range coupon.Categories {
+		if strings.EqualFold(allowed, *item.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedeemCoupon records a use of the coupon for the order. The usage limit is part
+// of the update filter so two concurrent checkouts can't both take the last use.
+func RedeemCoupon(ctx context.Context, couponCollection, redemptionCollection *mongo.Collection, coupon *models.Coupon, userID string, orderID primitive.ObjectID) error {
+	filter := bson.M{
+		"_id": coupon.Coupon_ID,
+		"$expr": bson.M{"$or": bson.A{
+			bson.M{"$eq": bson.A{"$usage_limit", 0}},
+			bson.M{"$lt": bson.A{"$used_count", "$usage_limit"}},
+		}},
+	}
+	result, err := couponCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"used_count": 1}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateCoupon
+	}
+	if result.MatchedCount == 0 {
+		return ErrCouponUsageLimit
+	}
+
+	redemption := models.CouponRedemption{
+		Redemption_ID: primitive.NewObjectID(),
+		Coupon_ID:     coupon.Coupon_ID,
+		User_ID:       userID,
+		Order_ID:      orderID,
+		Redeemed_At:   time.Now(),
+	}
+	if _, err = redemptionCollection.InsertOne(ctx, redemption); err != nil {
+		log.Println(err)
+		releaseUse(ctx, couponCollection, coupon.Coupon_ID)
+		return ErrCantUpdateCoupon
+	}
+
+	// Counted after the insert, so two checkouts racing for a user's last use
+	// see each other and both give it back rather than both getting it
+	if coupon.Per_User_Limit > 0 {
+		uses, err := CouponUses(ctx, redemptionCollection, coupon.Coupon_ID, userID)
+		if err != nil || uses > coupon.Per_User_Limit {
+			ReleaseCoupon(ctx, couponCollection, redemptionCollection, coupon.Coupon_ID, orderID)
+			if err != nil {
+				return err
+			}
+			return ErrCouponUserLimit
+		}
+	}
+	return nil
+}
+
+// ReleaseCoupon gives the use back when the order could not be placed after all
+func ReleaseCoupon(ctx context.Context, couponCollection, redemptionCollection *mongo.Collection, couponID primitive.ObjectID, orderID primitive.ObjectID) {
+	result, err := redemptionCollection.DeleteOne(ctx, bson.M{"coupon_id": couponID, "order_id": orderID})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if result.DeletedCount > 0 {
+		releaseUse(ctx, couponCollection, couponID)
+	}
+}
+
+func releaseUse(ctx context.Context, couponCollection *mongo.Collection, couponID primitive.ObjectID) {
+	if _, err := couponCollection.UpdateOne(ctx, bson.M{"_id": couponID}, bson.M{"$inc": bson.M{"used_count": -1}}); err != nil {
+		log.Println(err)
+	}
+}
+
+// PreviewCoupon prices the user's current cart with the coupon applied
+func PreviewCoupon(ctx context.Context, userCollection, couponCollection, redemptionCollection *mongo.Collection, pricer pricing.Pricer, userID string, code string) (*models.CartSummary, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+	if len(user.UserCart) == 0 {
+		return nil, ErrCartIsEmpty
+	}
+
+	coupon, err := FindCoupon(ctx, couponCollection, code)
+	if err != nil {
+		return nil, err
+	}
+	uses, err := CouponUses(ctx, redemptionCollection, coupon.Coupon_ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Shipping is estimated to the default address, checkout may pick another one
+	pincode := ""
+	if address, err := ResolveShippingAddress(&user, ""); err == nil {
+		pincode = *address.Pincode
+	}
+	return SummarizeCart(user.UserCart, coupon, uses, pincode, pricer, time.Now())
+}
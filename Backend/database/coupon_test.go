@@ -0,0 +1,110 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := now.Add(-24 * time.Hour)
+	inr := func(amount int64) *models.Money {
+		m := models.NewMoney(amount, "INR")
+		return &m
+	}
+	coupon := func(kind string, change func(c *models.Coupon)) *models.Coupon {
+		code := "SAVE"
+		c := &models.Coupon{Code: &code, Type: &kind}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	books, toys := "Books", "toys"
+	cart := []models.ProductUser{
+		{Price: *inr(20000), Quantity: 2, Category: &books},
+		{Price: *inr(10000), Quantity: 1, Category: &toys},
+	}
+
+	tests := []struct {
+		name   string
+		coupon *models.Coupon
+		uses   int
+		want   models.Money
+		err    error
+	}{
+		{"percentage of covered categories", coupon(models.CouponPercentage, func(c *models.Coupon) {
+			c.Percent = 10
+			c.Categories = []string{"books"}
+		}), 0, *inr(4000), nil},
+		{"fixed capped at covered lines", coupon(models.CouponFixed, func(c *models.Coupon) {
+			c.Amount = inr(50000)
+			c.Categories = []string{"toys"}
+		}), 0, *inr(10000), nil},
+		{"expired", coupon(models.CouponPercentage, func(c *models.Coupon) { c.Expires_At = &yesterday }), 0, models.Money{}, ErrCouponExpired},
+		{"below minimum", coupon(models.CouponPercentage, func(c *models.Coupon) { c.Min_Cart_Value = inr(50001) }), 0, models.Money{}, ErrCouponMinCartValue},
+		{"at minimum", coupon(models.CouponPercentage, func(c *models.Coupon) {
+			c.Percent = 20
+			c.Min_Cart_Value = inr(50000)
+		}), 0, *inr(10000), nil},
+		{"no covered item", coupon(models.CouponPercentage, func(c *models.Coupon) { c.Categories = []string{"garden"} }), 0, models.Money{}, ErrCouponCategory},
+		{"usage limit reached", coupon(models.CouponPercentage, func(c *models.Coupon) {
+			c.Usage_Limit = 100
+			c.Used_Count = 100
+		}), 0, models.Money{}, ErrCouponUsageLimit},
+		{"user limit reached", coupon(models.CouponPercentage, func(c *models.Coupon) { c.Per_User_Limit = 2 }), 2, models.Money{}, ErrCouponUserLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CouponDiscount(tt.coupon, cart, tt.uses, now)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeCartTaxesDiscountedLines(t *testing.T) {
+	percentage, code := models.CouponPercentage, "BOOKS10"
+	books, toys := "books", "toys"
+	items := []models.ProductUser{
+		{Price: models.NewMoney(20000, "INR"), Quantity: 1, Category: &books},
+		{Price: models.NewMoney(10000, "INR"), Quantity: 1, Category: &toys},
+	}
+	coupon := &models.Coupon{Code: &code, Type: &percentage, Percent: 10, Categories: []string{"books"}}
+	pricer := pricing.Pricer{
+		Shipping: pricing.FlatRate{Amount: models.NewMoney(4000, "INR")},
+		Tax:      pricing.CategoryRates{Default: 1000},
+	}
+
+	summary, err := SummarizeCart(items, coupon, 0, "110001", pricer, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2000 off the books line only: (18000 + 10000) * 10%
+	want := map[string]models.Money{
+		"subtotal": models.NewMoney(30000, "INR"),
+		"discount": models.NewMoney(2000, "INR"),
+		"tax":      models.NewMoney(2800, "INR"),
+		"shipping": models.NewMoney(4000, "INR"),
+		"total":    models.NewMoney(34800, "INR"),
+	}
+	got := map[string]models.Money{
+		"subtotal": summary.Subtotal,
+		"discount": summary.Discount,
+		"tax":      summary.Tax,
+		"shipping": summary.Shipping,
+		"total":    summary.Total,
+	}
+	for field, amount := range want {
+		if got[field] != amount {
+			t.Errorf("%s = %v, want %v", field, got[field], amount)
+		}
+	}
+}
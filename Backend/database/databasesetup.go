@@ -9,32 +9,11 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Indexing MongoDB collections for better performance
-func CreateProductIndexes(client *mongo.Client) {
-	productCol := ProductData(client, "Products")
-	indexModel := mongo.IndexModel{
-		Keys: bson.D{
-			{Key: "product_name", Value: "text"},
-			{Key: "category", Value: 1},
-		},
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	_, err := productCol.Indexes().CreateOne(ctx, indexModel)
-	if err != nil {
-		fmt.Println("Warning: Could not create index:", err)
-	} else {
-		fmt.Println("Successfully optimized Product indexes")
-	}
-}
-
 func DBSet() *mongo.Client {
 	godotenv.Load()
 	dbURL := os.Getenv("DB_URL")
@@ -77,13 +56,9 @@ func DBSet() *mongo.Client {
 	}
 
 	fmt.Println("Successfully connected to mongoDB")
-
-	CreateProductIndexes(client)
 	return client
 }
 
-var Client *mongo.Client = DBSet()
-
 func UserData(client *mongo.Client, collectionName string) *mongo.Collection {
 	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
 	return collection
@@ -93,3 +68,58 @@ func ProductData(client *mongo.Client, collectionName string) *mongo.Collection
 	var productCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
 	return productCollection
 }
+
+func PaymentData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var paymentCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return paymentCollection
+}
+
+func IdempotencyData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var idempotencyCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return idempotencyCollection
+}
+
+func CouponData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var couponCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return couponCollection
+}
+
+func RedemptionData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var redemptionCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return redemptionCollection
+}
+
+func SearchData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var searchCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return searchCollection
+}
+
+func CategoryData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var categoryCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return categoryCollection
+}
+
+func ReviewData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var reviewCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return reviewCollection
+}
+
+func ImportData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var importCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return importCollection
+}
+
+func GuestCartData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var guestCartCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return guestCartCollection
+}
+
+func ProductViewData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var productViewCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return productViewCollection
+}
+
+func RecommendationData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var recommendationCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return recommendationCollection
+}
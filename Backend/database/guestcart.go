@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidCartToken = errors.New("invalid cart token")
+	ErrCantMergeCart    = errors.New("cannot merge the guest cart")
+)
+
+const guestTokenBytes = 24
+
+// NewGuestCartToken returns an unguessable token, the token is the only thing
+// that gives access to a guest cart
+func NewGuestCartToken() string {
+	token := make([]byte, guestTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		log.Panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func ValidGuestCartToken(token string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(decoded) == guestTokenBytes
+}
+
+// mergeCartItem bumps the quantity when the product is already in the cart and
+// pushes the item otherwise. It works on any document with a usercart array,
+// upsert creates the document when it doesn't exist yet.
+func mergeCartItem(ctx context.Context, collection *mongo.Collection, ownerID interface{}, item models.ProductUser, extra bson.M, upsert bool) error {
+	incUpdate := bson.M{"$inc": bson.M{"usercart.$.quantity": item.Quantity}}
+	pushUpdate := bson.M{"$push": bson.M{"usercart": item}}
+	for operator, fields := range extra {
+		incUpdate[operator] = fields
+		pushUpdate[operator] = fields
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": ownerID, "usercart._id": item.Product_ID}, incUpdate)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": ownerID}, pushUpdate, options.Update().SetUpsert(upsert))
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+func AddProductToGuestCart(ctx context.Context, prodCollection, guestCollection *mongo.Collection, productID primitive.ObjectID, token string, quantity int) error {
+	if quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+	if !ValidGuestCartToken(token) {
+		return ErrInvalidCartToken
+	}
+
+	cart, err := FindGuestCart(ctx, guestCollection, token)
+	if err != nil {
+		return err
+	}
+	item, err := cartItem(ctx, prodCollection, productID, quantity, cartQuantity(cart.UserCart, productID))
+	if err != nil {
+		return err
+	}
+
+	// Every write pushes the expiry back, only abandoned carts expire
+	extra := bson.M{
+		"$set":         bson.M{"updated_at": time.Now()},
+		"$setOnInsert": bson.M{"created_at": time.Now()},
+	}
+	return mergeCartItem(ctx, guestCollection, token, *item, extra, true)
+}
+
+func RemoveGuestCartItem(ctx context.Context, guestCollection *mongo.Collection, productID primitive.ObjectID, token string) error {
+	if !ValidGuestCartToken(token) {
+		return ErrInvalidCartToken
+	}
+	update := bson.M{
+		"$pull": bson.M{"usercart": bson.M{"_id": productID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+	_, err := guestCollection.UpdateOne(ctx, bson.M{"_id": token}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantRemoveItemCart
+	}
+	return nil
+}
+
+// FindGuestCart returns the cart behind the token, an expired or unknown token
+// gets an empty cart
+func FindGuestCart(ctx context.Context, guestCollection *mongo.Collection, token string) (*models.GuestCart, error) {
+	if !ValidGuestCartToken(token) {
+		return nil, ErrInvalidCartToken
+	}
+	var cart models.GuestCart
+	err := guestCollection.FindOne(ctx, bson.M{"_id": token}).Decode(&cart)
+	if err == mongo.ErrNoDocuments {
+		return &models.GuestCart{Token: token, UserCart: make([]models.ProductUser, 0)}, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	return &cart, nil
+}
+
+// MergeGuestCart moves the guest cart into the user's cart, quantities of
+// products in both are added up. Every item is priced and checked against the
+// stock again, the guest may have added it days ago; items that can no longer
+// be bought are dropped. The guest cart is taken first so two logins racing
+// with the same token can't merge it twice.
+func MergeGuestCart(ctx context.Context, prodCollection, guestCollection, userCollection *mongo.Collection, token string, userID string) error {
+	if !ValidGuestCartToken(token) {
+		return ErrInvalidCartToken
+	}
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	userItems, err := userCart(ctx, userCollection, id)
+	if err != nil {
+		return err
+	}
+
+	var cart models.GuestCart
+	err = guestCollection.FindOneAndDelete(ctx, bson.M{"_id": token}).Decode(&cart)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantMergeCart
+	}
+
+	for i, guestItem := range cart.UserCart {
+		quantity := guestItem.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		item, err := cartItem(ctx, prodCollection, guestItem.Product_ID, quantity, cartQuantity(userItems, guestItem.Product_ID))
+		switch err {
+		case nil:
+		case ErrCantFindProoduct, ErrProductNotForSale, ErrOutOfStock:
+			log.Printf("guest cart %s: dropping product %s: %v", token, guestItem.Product_ID.Hex(), err)
+			continue
+		default:
+			restoreGuestCart(ctx, guestCollection, cart, i)
+			return ErrCantMergeCart
+		}
+		if err = mergeCartItem(ctx, userCollection, id, *item, bson.M{"$set": cartTouched()}, false); err != nil {
+			restoreGuestCart(ctx, guestCollection, cart, i)
+			return ErrCantMergeCart
+		}
+		userItems = append(userItems, *item)
+	}
+	return nil
+}
+
+// restoreGuestCart puts back the items from index from on, the ones that were
+// not merged, so the shopper can try again
+func restoreGuestCart(ctx context.Context, guestCollection *mongo.Collection, cart models.GuestCart, from int) {
+	cart.UserCart = cart.UserCart[from:]
+	cart.Updated_At = time.Now()
+	if _, err := guestCollection.InsertOne(ctx, cart); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrImageNotFound     = errors.New("image not found")
+	ErrCantUpdateProduct = errors.New("cannot update the product")
+)
+
+// AddProductImages appends the images to the product. The first image becomes
+// the primary one when the product has none yet.
+func AddProductImages(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID, images []models.ProductImage) (*models.Product, error) {
+	update := bson.M{"$push": bson.M{"images": bson.M{"$each": images}}}
+	result, err := prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, update)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrCantFindProoduct
+	}
+
+	noPrimary := bson.M{"_id": productID, "$or": bson.A{bson.M{"image": nil}, bson.M{"image": ""}}}
+	_, err = prodCollection.UpdateOne(ctx, noPrimary, bson.M{"$set": bson.M{"image": images[0].URL}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+	return FindProduct(ctx, prodCollection, productID)
+}
+
+// RemoveProductImage takes the image off the product and returns it so the
+// caller can delete the files. When it was the primary image the next one takes over.
+func RemoveProductImage(ctx context.Context, prodCollection *mongo.Collection, productID, imageID primitive.ObjectID) (*models.ProductImage, error) {
+	var product models.Product
+	filter := bson.M{"_id": productID}
+	update := bson.M{"$pull": bson.M{"images": bson.M{"_id": imageID}}}
+	err := prodCollection.FindOneAndUpdate(ctx, filter, update).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindProoduct
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+
+	var removed *models.ProductImage
+	var next interface{}
+	for i := range product.Images {
+		if product.Images[i].Image_ID == imageID {
+			removed = &product.Images[i]
+		} else if next == nil {
+			next = product.Images[i].URL
+		}
+	}
+	if removed == nil {
+		return nil, ErrImageNotFound
+	}
+
+	if product.Image != nil && *product.Image == removed.URL {
+		_, err = prodCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"image": next}})
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantUpdateProduct
+		}
+	}
+	return removed, nil
+}
+
+// SetPrimaryImage makes one of the product's uploaded images the one shown first
+func SetPrimaryImage(ctx context.Context, prodCollection *mongo.Collection, productID, imageID primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+	filter := bson.M{"_id": productID, "images._id": imageID}
+	projection := options.FindOne().SetProjection(bson.M{"images.$": 1})
+	err := prodCollection.FindOne(ctx, filter, projection).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrImageNotFound
+	}
+	if err != nil || len(product.Images) == 0 {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+
+	_, err = prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": bson.M{"image": product.Images[0].URL}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+	return FindProduct(ctx, prodCollection, productID)
+}
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexSpec declares one index. Indexes are matched on their name, which is
+// the name MongoDB gives an index by default so the indexes created before
+// the registry existed are recognised.
+type IndexSpec struct {
+	Collection string
+	Keys       bson.D
+	Unique     bool
+	// Only documents matching the filter are indexed
+	Partial bson.M
+	// Documents expire this long after the indexed date, 0 keeps them
+	Expire_After time.Duration
+}
+
+// Indexes is every index of the Ecommerce database
+var Indexes = []IndexSpec{
+	// Text search over names, faceted by category
+	{Collection: "Products", Keys: bson.D{{Key: "product_name", Value: "text"}, {Key: "category", Value: 1}}},
+	{Collection: "Products", Keys: bson.D{{Key: "category_id", Value: 1}}},
+	// Prefix search matches typed words against the start of the name's words
+	{Collection: "Products", Keys: bson.D{{Key: "name_tokens", Value: 1}}},
+	// Sort orders of the rating aggregate and listings
+	{Collection: "Products", Keys: bson.D{{Key: "rating", Value: -1}, {Key: "_id", Value: -1}}},
+	{Collection: "Products", Keys: bson.D{{Key: "review_count", Value: -1}, {Key: "_id", Value: -1}}},
+	// Imported products are matched on sku, products created by hand have none
+	{Collection: "Products", Keys: bson.D{{Key: "sku", Value: 1}}, Unique: true, Partial: bson.M{"sku": bson.M{"$type": "string"}}},
+
+	// Signup and login look users up by email and phone, the token by user_id.
+	// Users without an email or phone are left out, they don't clash on null.
+	{Collection: "Users", Keys: bson.D{{Key: "email", Value: 1}}, Unique: true, Partial: bson.M{"email": bson.M{"$type": "string"}}},
+	{Collection: "Users", Keys: bson.D{{Key: "phone", Value: 1}}, Unique: true, Partial: bson.M{"phone": bson.M{"$type": "string"}}},
+	{Collection: "Users", Keys: bson.D{{Key: "user_id", Value: 1}}},
+	// Order status updates find the user through the order
+	{Collection: "Users", Keys: bson.D{{Key: "orders._id", Value: 1}}},
+	// Price drop checks look users up by the products on their wishlist
+	{Collection: "Users", Keys: bson.D{{Key: "wishlist._id", Value: 1}}},
+	// The abandoned cart job scans users by when their cart last changed
+	{Collection: "Users", Keys: bson.D{{Key: "cart_updated_at", Value: 1}}, Partial: bson.M{"cart_updated_at": bson.M{"$exists": true}}},
+
+	// Stored idempotent responses are replayed for 24 hours
+	{Collection: "IdempotencyKeys", Keys: bson.D{{Key: "created_at", Value: 1}}, Expire_After: 24 * time.Hour},
+
+	// Checkout looks coupons up by code
+	{Collection: "Coupons", Keys: bson.D{{Key: "code", Value: 1}}, Unique: true},
+	// One redemption per coupon and order, per user limits count a user's redemptions
+	{Collection: "CouponRedemptions", Keys: bson.D{{Key: "coupon_id", Value: 1}, {Key: "order_id", Value: 1}}, Unique: true},
+	{Collection: "CouponRedemptions", Keys: bson.D{{Key: "coupon_id", Value: 1}, {Key: "user_id", Value: 1}}},
+
+	// Category slugs are unique and subtree lookups go through ancestors
+	{Collection: "Categories", Keys: bson.D{{Key: "slug", Value: 1}}, Unique: true},
+	{Collection: "Categories", Keys: bson.D{{Key: "ancestors", Value: 1}}},
+	{Collection: "Categories", Keys: bson.D{{Key: "parent_id", Value: 1}}},
+
+	// One review per customer and product, listings page through a product's
+	// reviews and moderation through a status
+	{Collection: "Reviews", Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}}, Unique: true},
+	{Collection: "Reviews", Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+	{Collection: "Reviews", Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+
+	// The importer picks queued jobs up oldest first
+	{Collection: "ImportJobs", Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+
+	// Suggestions start from the most searched terms, a term nobody searched for
+	// in 90 days is dropped
+	{Collection: "SearchTerms", Keys: bson.D{{Key: "count", Value: -1}}},
+	{Collection: "SearchTerms", Keys: bson.D{{Key: "last_searched_at", Value: 1}}, Expire_After: 90 * 24 * time.Hour},
+
+	// Recently viewed reads a user's views newest first, a view is kept for 90 days
+	{Collection: "ProductViews", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "product_id", Value: 1}}, Unique: true},
+	{Collection: "ProductViews", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "viewed_at", Value: -1}}},
+	{Collection: "ProductViews", Keys: bson.D{{Key: "viewed_at", Value: 1}}, Expire_After: 90 * 24 * time.Hour},
+
+	// Guest carts expire a week after the shopper last touched them
+	{Collection: "GuestCarts", Keys: bson.D{{Key: "updated_at", Value: 1}}, Expire_After: 7 * 24 * time.Hour},
+}
+
+// Name is the default MongoDB name of the index, its keys and directions joined by underscores
+func (s IndexSpec) Name() string {
+	parts := make([]string, 0, len(s.Keys))
+	for _, key := range s.Keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	indexOptions := options.Index().SetName(s.Name())
+	if s.Unique {
+		indexOptions.SetUnique(true)
+	}
+	if s.Partial != nil {
+		indexOptions.SetPartialFilterExpression(s.Partial)
+	}
+	if s.Expire_After > 0 {
+		indexOptions.SetExpireAfterSeconds(int32(s.Expire_After.Seconds()))
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: indexOptions}
+}
+
+// existingIndex is the part of listIndexes the registry compares
+type existingIndex struct {
+	Name         string `bson:"name"`
+	Unique       bool   `bson:"unique"`
+	Partial      bson.M `bson:"partialFilterExpression"`
+	Expire_After *int64 `bson:"expireAfterSeconds"`
+}
+
+func (e existingIndex) matches(s IndexSpec) bool {
+	expire := int64(0)
+	if e.Expire_After != nil {
+		expire = *e.Expire_After
+	}
+	return e.Unique == s.Unique && expire == int64(s.Expire_After.Seconds()) && samePartial(e.Partial, s.Partial)
+}
+
+// samePartial compares filters the way the server stores them
+func samePartial(existing, declared bson.M) bool {
+	if len(existing) == 0 || len(declared) == 0 {
+		return len(existing) == len(declared)
+	}
+	data, err := bson.Marshal(declared)
+	if err != nil {
+		return false
+	}
+	var stored bson.M
+	if err = bson.Unmarshal(data, &stored); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(existing, stored)
+}
+
+// IndexProblem is an index that is not the way the registry declares it
+type IndexProblem struct {
+	Collection string
+	Name       string
+	Error      string
+}
+
+// IndexReport is what ReconcileIndexes found. Extra indexes are never dropped
+// and changed ones are not rebuilt, both need someone to decide.
+type IndexReport struct {
+	Created []IndexProblem // missing indexes, only created when not a dry run
+	Extra   []IndexProblem
+	Changed []IndexProblem // same name, different options
+	Failed  []IndexProblem
+	Dry_Run bool
+}
+
+// ReconcileIndexes compares the registry with the indexes in db and creates
+// the missing ones unless dryRun is set
+func ReconcileIndexes(ctx context.Context, db *mongo.Database, specs []IndexSpec, dryRun bool) (*IndexReport, error) {
+	report := &IndexReport{Dry_Run: dryRun}
+
+	byCollection := make(map[string][]IndexSpec)
+	collections := make([]string, 0)
+	for _, spec := range specs {
+		if _, ok := byCollection[spec.Collection]; !ok {
+			collections = append(collections, spec.Collection)
+		}
+		byCollection[spec.Collection] = append(byCollection[spec.Collection], spec)
+	}
+	sort.Strings(collections)
+
+	for _, collection := range collections {
+		indexes := db.Collection(collection).Indexes()
+		cursor, err := indexes.List(ctx)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		var existing []existingIndex
+		if err = cursor.All(ctx, &existing); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		found := make(map[string]existingIndex, len(existing))
+		for _, index := range existing {
+			found[index.Name] = index
+		}
+
+		for _, spec := range byCollection[collection] {
+			name := spec.Name()
+			index, ok := found[name]
+			delete(found, name)
+			if ok {
+				if !index.matches(spec) {
+					report.Changed = append(report.Changed, IndexProblem{Collection: collection, Name: name})
+				}
+				continue
+			}
+			if dryRun {
+				report.Created = append(report.Created, IndexProblem{Collection: collection, Name: name})
+				continue
+			}
+			if _, err = indexes.CreateOne(ctx, spec.model()); err != nil {
+				report.Failed = append(report.Failed, IndexProblem{Collection: collection, Name: name, Error: err.Error()})
+				continue
+			}
+			report.Created = append(report.Created, IndexProblem{Collection: collection, Name: name})
+		}
+
+		delete(found, "_id_")
+		for name := range found {
+			report.Extra = append(report.Extra, IndexProblem{Collection: collection, Name: name})
+		}
+	}
+	return report, nil
+}
+
+func (r *IndexReport) Write(out io.Writer) {
+	created := "created"
+	if r.Dry_Run {
+		created = "missing"
+	}
+	sections := []struct {
+		label    string
+		problems []IndexProblem
+	}{
+		{created, r.Created},
+		{"changed, drop it to have it recreated", r.Changed},
+		{"extra, not in the registry", r.Extra},
+		{"could not be created", r.Failed},
+	}
+	clean := true
+	for _, section := range sections {
+		sort.Slice(section.problems, func(i, j int) bool {
+			a, b := section.problems[i], section.problems[j]
+			return a.Collection < b.Collection || (a.Collection == b.Collection && a.Name < b.Name)
+		})
+		for _, problem := range section.problems {
+			clean = false
+			if problem.Error != "" {
+				fmt.Fprintf(out, "index %s.%s %s: %s\n", problem.Collection, problem.Name, section.label, problem.Error)
+			} else {
+				fmt.Fprintf(out, "index %s.%s %s\n", problem.Collection, problem.Name, section.label)
+			}
+		}
+	}
+	if clean {
+		fmt.Fprintln(out, "indexes are up to date")
+	}
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrOrderNotFound         = errors.New("order not found")
+	ErrInvalidOrderStatus    = errors.New("status must be shipped, delivered or cancelled")
+	ErrOrderStatusTransition = errors.New("the order can't move to this status from its current one")
+	ErrCantUpdateOrder       = errors.New("cannot update the order")
+)
+
+// orderTransitions lists the statuses an order may be in before moving to the key.
+// Orders placed before statuses existed have none and count as placed.
+var orderTransitions = map[string][]string{
+	models.OrderStatusShipped:   {"", models.OrderStatusPlaced},
+	models.OrderStatusDelivered: {"", models.OrderStatusPlaced, models.OrderStatusShipped},
+	models.OrderStatusCancelled: {"", models.OrderStatusPlaced, models.OrderStatusShipped},
+}
+
+// UpdateOrderStatus moves an order forward, delivered orders get a timestamp
+// which is what makes their products reviewable
+func UpdateOrderStatus(ctx context.Context, userCollection *mongo.Collection, orderID primitive.ObjectID, status string) error {
+	from, ok := orderTransitions[status]
+	if !ok {
+		return ErrInvalidOrderStatus
+	}
+
+	set := bson.M{"orders.$.status": status}
+	if status == models.OrderStatusDelivered {
+		set["orders.$.delivered_at"] = time.Now()
+	}
+	filter := bson.M{"orders": bson.M{"$elemMatch": bson.M{"_id": orderID, "status": bson.M{"$in": from}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := userCollection.CountDocuments(ctx, bson.M{"orders._id": orderID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+	if count == 0 {
+		return ErrOrderNotFound
+	}
+	return ErrOrderStatusTransition
+}
+
+// CheckOrderTransition reports whether an order in status from may move to status to
+func CheckOrderTransition(from string, to string) error {
+	allowed, ok := orderTransitions[to]
+	if !ok {
+		return ErrInvalidOrderStatus
+	}
+	for _, status := range allowed {
+		if status == from {
+			return nil
+		}
+	}
+	return ErrOrderStatusTransition
+}
+
+// deliveredOrderWith returns the most recent delivered order of the user that
+// contained the product
+func deliveredOrderWith(ctx context.Context, userCollection *mongo.Collection, userID primitive.ObjectID, productID primitive.ObjectID) (*models.Order, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": userID}}},
+		{{Key: "$unwind", Value: "$orders"}},
+		{{Key: "$match", Value: bson.M{"orders.status": models.OrderStatusDelivered, "orders.order_cart._id": productID}}},
+		{{Key: "$sort", Value: bson.M{"orders.delivered_at": -1}}},
+		{{Key: "$limit", Value: 1}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$orders"}}},
+	}
+	cursor, err := userCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	if len(orders) == 0 {
+		return nil, ErrNotVerifiedPurchase
+	}
+	return &orders[0], nil
+}
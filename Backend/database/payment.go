@@ -0,0 +1,221 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/payment"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	PaymentMethodCOD     = "cod"
+	PaymentMethodDigital = "digital"
+)
+
+var (
+	ErrInvalidPaymentMethod = errors.New("payment method must be cod or digital")
+	ErrPaymentFailed        = errors.New("payment could not be completed")
+	ErrCantFindPayment      = errors.New("can't find the payment")
+	ErrCantUpdatePayment    = errors.New("cannot update the payment")
+	ErrCantRefundPayment    = errors.New("cannot refund the payment")
+	ErrRefundTooLarge       = errors.New("the payment can't be refunded by this much")
+)
+
+// ChargeOrder authorizes and captures the order total with the provider and
+// stores a payment intent linked to the order.
+func ChargeOrder(ctx context.Context, paymentCollection *mongo.Collection, provider payment.Provider, order *models.Order, userID string) (*models.PaymentIntent, error) {
+	intent := models.PaymentIntent{
+		Intent_ID:  primitive.NewObjectID(),
+		Order_ID:   order.Order_ID,
+		User_ID:    userID,
+		Provider:   provider.Name(),
+		Amount:     order.Price,
+		Status:     models.PaymentStatusFailed,
+		Created_At: time.Now(),
+		Updated_At: time.Now(),
+	}
+
+	ref, err := provider.Authorize(ctx, order.Price, order.Order_ID.Hex())
+	if err == nil {
+		intent.Provider_Ref = ref
+		intent.Status = models.PaymentStatusAuthorized
+		err = provider.Capture(ctx, ref)
+		if err == nil {
+			intent.Status = models.PaymentStatusCaptured
+		}
+	}
+
+	// The intent is recorded even when the charge fails so there is a trail of the attempt
+	if _, insertErr := paymentCollection.InsertOne(ctx, intent); insertErr != nil {
+		log.Println(insertErr)
+		if intent.Status == models.PaymentStatusCaptured {
+			_ = provider.Refund(ctx, ref, intent.Amount)
+		}
+		return nil, ErrCantUpdatePayment
+	}
+
+	if err != nil {
+		log.Println(err)
+		return nil, ErrPaymentFailed
+	}
+	return &intent, nil
+}
+
+// refundableStatuses are the statuses a payment can be refunded from
+var refundableStatuses = []string{models.PaymentStatusCaptured, models.PaymentStatusPartiallyRefunded}
+
+// paymentTransitions lists the statuses a webhook may move a payment from, so a
+// late or replayed event never takes a payment back to an earlier status
+var paymentTransitions = map[string][]string{
+	models.PaymentStatusAuthorized: {},
+	models.PaymentStatusCaptured:   {models.PaymentStatusAuthorized},
+	models.PaymentStatusRefunded:   {models.PaymentStatusCaptured, models.PaymentStatusPartiallyRefunded},
+	models.PaymentStatusFailed:     {models.PaymentStatusAuthorized},
+}
+
+// addRefund moves amount into refunded in a single update and sets the status
+// from the new total. Without guard a negative amount takes a refund back out.
+func addRefund(ctx context.Context, paymentCollection *mongo.Collection, intentID primitive.ObjectID, amount models.Money, guard bool) (bool, error) {
+	refunded := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$refunded.amount", 0}}, amount.Amount}}
+	filter := bson.M{"_id": intentID, "amount.currency": amount.Currency}
+	if guard {
+		// Concurrent refunds can't take out more than was captured together
+		filter["status"] = bson.M{"$in": refundableStatuses}
+		filter["$expr"] = bson.M{"$lte": bson.A{refunded, "$amount.amount"}}
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"refunded":   bson.M{"amount": refunded, "currency": amount.Currency},
+		"status":     refundStatus(refunded),
+		"updated_at": "$$NOW",
+	}}}}
+	result, err := paymentCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantUpdatePayment
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// refundStatus is the status of a payment once refunded is its refunded total
+func refundStatus(refunded interface{}) bson.M {
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$gte": bson.A{refunded, "$amount.amount"}}, "then": models.PaymentStatusRefunded},
+			bson.M{"case": bson.M{"$gt": bson.A{refunded, 0}}, "then": models.PaymentStatusPartiallyRefunded},
+		},
+		"default": models.PaymentStatusCaptured,
+	}}
+}
+
+func RefundPayment(ctx context.Context, paymentCollection *mongo.Collection, provider payment.Provider, intentID primitive.ObjectID, amount models.Money) (*models.PaymentIntent, error) {
+	var intent models.PaymentIntent
+	err := paymentCollection.FindOne(ctx, bson.M{"_id": intentID}).Decode(&intent)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindPayment
+	}
+
+	// A zero amount refunds whatever has not been refunded yet
+	if amount.IsZero() {
+		amount = models.NewMoney(intent.Amount.Amount-intent.Refunded.Amount, intent.Amount.Currency)
+	}
+	if amount.Currency != intent.Amount.Currency {
+		return nil, models.ErrCurrencyMismatch
+	}
+	if amount.Amount <= 0 {
+		return nil, ErrRefundTooLarge
+	}
+
+	// The refund is booked before the provider is called and taken back if the
+	// provider fails, so two refunds racing can't both pass the balance check
+	ok, err := addRefund(ctx, paymentCollection, intentID, amount, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrRefundTooLarge
+	}
+
+	if err = provider.Refund(ctx, intent.Provider_Ref, amount); err != nil {
+		log.Println(err)
+		if _, undoErr := addRefund(ctx, paymentCollection, intentID, models.NewMoney(-amount.Amount, amount.Currency), false); undoErr != nil {
+			log.Println(undoErr)
+		}
+		return nil, ErrCantRefundPayment
+	}
+
+	err = paymentCollection.FindOne(ctx, bson.M{"_id": intentID}).Decode(&intent)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantFindPayment
+	}
+	return &intent, nil
+}
+
+// ApplyPaymentEvent syncs a verified webhook event onto the stored payment intent.
+// Events that would move the payment backwards are ignored.
+func ApplyPaymentEvent(ctx context.Context, paymentCollection *mongo.Collection, event *payment.WebhookEvent) error {
+	var status string
+	switch event.Type {
+	case payment.EventAuthorized:
+		status = models.PaymentStatusAuthorized
+	case payment.EventCaptured:
+		status = models.PaymentStatusCaptured
+	case payment.EventRefunded:
+		status = models.PaymentStatusRefunded
+	case payment.EventFailed:
+		status = models.PaymentStatusFailed
+	default:
+		return payment.ErrInvalidPayload
+	}
+
+	filter := bson.M{"provider_ref": event.Provider_Ref, "status": bson.M{"$in": paymentTransitions[status]}}
+	var update interface{} = bson.M{"$set": bson.M{"status": status, "updated_at": time.Now()}}
+	if event.Type == payment.EventRefunded {
+		filter, update = refundEvent(filter, event.Amount)
+	}
+	result, err := paymentCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePayment
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := paymentCollection.CountDocuments(ctx, bson.M{"provider_ref": event.Provider_Ref})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdatePayment
+	}
+	if count == 0 {
+		return ErrCantFindPayment
+	}
+	return nil
+}
+
+// refundEvent books a refund the provider reported. The event carries the total
+// refunded so far, so a refund booked by RefundPayment and then reported again
+// isn't counted twice. A total that isn't larger than the one stored changes
+// nothing, an event without an amount refunds the whole payment.
+func refundEvent(filter bson.M, total models.Money) (bson.M, mongo.Pipeline) {
+	stored := bson.M{"$ifNull": bson.A{"$refunded.amount", 0}}
+	var reported interface{} = "$amount.amount"
+	if !total.IsZero() {
+		filter["amount.currency"] = total.Currency
+		reported = bson.M{"$min": bson.A{total.Amount, "$amount.amount"}}
+	}
+	refunded := bson.M{"$max": bson.A{stored, reported}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"refunded":   bson.M{"amount": refunded, "currency": "$amount.currency"},
+		"status":     refundStatus(refunded),
+		"updated_at": "$$NOW",
+	}}}}
+	return filter, update
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
+)
+
+// SummarizeCart prices the items the same way checkout does: subtotal, coupon
+// discount, tax on the discounted amount and shipping to the pincode.
+// A nil coupon means no discount, userUses is how often the user redeemed it before.
+func SummarizeCart(items []models.ProductUser, coupon *models.Coupon, userUses int, pincode string, pricer pricing.Pricer, now time.Time) (*models.CartSummary, error) {
+	var summary models.CartSummary
+	var err error
+	if summary.Subtotal, err = pricing.Subtotal(items); err != nil {
+		return nil, err
+	}
+
+	if coupon != nil {
+		discount, err := CouponDiscount(coupon, items, userUses, now)
+		if err != nil {
+			return nil, err
+		}
+		summary.Discount = discount
+		summary.Coupon_Code = coupon.Code
+		summary.Free_Shipping = *coupon.Type == models.CouponFreeShipping
+	}
+
+	discount := pricing.Discount{Amount: summary.Discount}
+	if coupon != nil {
+		discount.Applies = func(item models.ProductUser) bool { return CouponCovers(coupon, item) }
+	}
+	if summary.Tax, err = pricer.Tax.Tax(items, discount); err != nil {
+		return nil, err
+	}
+
+	shipping, err := pricer.Shipping.Shipping(items, summary.Subtotal, pincode)
+	if err != nil {
+		return nil, err
+	}
+	if !summary.Free_Shipping {
+		summary.Shipping = shipping
+	}
+
+	total, err := summary.Subtotal.Sub(summary.Discount)
+	if err == nil {
+		total, err = total.Add(summary.Tax)
+	}
+	if err == nil {
+		total, err = total.Add(summary.Shipping)
+	}
+	if err != nil {
+		return nil, err
+	}
+	summary.Total = total
+	return &summary, nil
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("sort must be newest, price, rating or reviews")
+	ErrCantListItems = errors.New("cannot list the products")
+)
+
+// ProductListQuery is everything /users/productview can filter and sort on
+type ProductListQuery struct {
+	Sort      string // newest, price, rating or reviews
+	Ascending bool
+	Category  string
+	// A category and its descendants, resolved with CategoryAndDescendants
+	Category_IDs []primitive.ObjectID
+	Min_Price    *models.Money
+	Max_Price    *models.Money
+	Min_Rating   *float64
+	Cursor       string
+	Limit        int
+}
+
+// listCursor remembers where the previous page stopped. It is BSON encoded so
+// the sort value keeps its exact type between requests.
+type listCursor struct {
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func sortField(sort string) (string, error) {
+	switch sort {
+	case "", "newest":
+		return "_id", nil
+	case "price":
+		return "price.amount", nil
+	case "rating":
+		return "rating", nil
+	case "reviews":
+		return "review_count", nil
+	}
+	return "", ErrInvalidSort
+}
+
+func encodeCursor(value interface{}, id primitive.ObjectID) string {
+	data, err := bson.Marshal(listCursor{Value: value, ID: id})
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var decoded listCursor
+	if err = bson.Unmarshal(data, &decoded); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &decoded, nil
+}
+
+// keysetFilter matches the documents that sort after the cursor. Missing values
+// sort first ascending and last descending, same as MongoDB orders them.
+func keysetFilter(field string, ascending bool, cursor *listCursor) bson.M {
+	op := "$lt"
+	if ascending {
+		op = "$gt"
+	}
+	if field == "_id" {
+		return bson.M{"_id": bson.M{op: cursor.ID}}
+	}
+
+	if cursor.Value == nil {
+		if ascending {
+			return bson.M{"$or": bson.A{
+				bson.M{field: nil, "_id": bson.M{op: cursor.ID}},
+				bson.M{field: bson.M{"$ne": nil}},
+			}}
+		}
+		return bson.M{field: nil, "_id": bson.M{op: cursor.ID}}
+	}
+
+	after := bson.A{
+		bson.M{field: bson.M{op: cursor.Value}},
+		bson.M{field: cursor.Value, "_id": bson.M{op: cursor.ID}},
+	}
+	if !ascending {
+		after = append(after, bson.M{field: nil})
+	}
+	return bson.M{"$or": after}
+}
+
+// productFilter turns the query filters into a Mongo filter, without the cursor
+func productFilter(query ProductListQuery) bson.M {
+	filter := bson.M{}
+	if query.Category != "" {
+		filter["category"] = query.Category
+	}
+	if len(query.Category_IDs) > 0 {
+		filter["category_id"] = bson.M{"$in": query.Category_IDs}
+	}
+
+	price := bson.M{}
+	if query.Min_Price != nil {
+		price["$gte"] = query.Min_Price.Amount
+		filter["price.currency"] = query.Min_Price.Currency
+	}
+	if query.Max_Price != nil {
+		price["$lte"] = query.Max_Price.Amount
+		filter["price.currency"] = query.Max_Price.Currency
+	}
+	if len(price) > 0 {
+		filter["price.amount"] = price
+	}
+
+	if query.Min_Rating != nil {
+		filter["rating"] = bson.M{"$gte": *query.Min_Rating}
+	}
+	return filter
+}
+
+func FindProduct(ctx context.Context, prodCollection *mongo.Collection, productID primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCantFindProoduct
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+	return &product, nil
+}
+
+// ListProducts returns one page of products using keyset pagination, so deep
+// pages cost the same as the first one
+func ListProducts(ctx context.Context, prodCollection *mongo.Collection, query ProductListQuery) (*models.ProductPage, error) {
+	field, err := sortField(query.Sort)
+	if err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageSize
+	}
+	if query.Limit > MaxPageSize {
+		query.Limit = MaxPageSize
+	}
+
+	filter := productFilter(query)
+	total, err := prodCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListItems
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, keysetFilter(field, query.Ascending, cursor)}}
+	}
+
+	direction := -1
+	if query.Ascending {
+		direction = 1
+	}
+	sort := bson.D{{Key: field, Value: direction}}
+	if field != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+
+	// One extra document tells us whether there is a next page
+	findOptions := options.Find().SetSort(sort).SetLimit(int64(query.Limit + 1))
+	cursor, err := prodCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListItems
+	}
+	defer cursor.Close(ctx)
+
+	var raw []bson.Raw
+	if err = cursor.All(ctx, &raw); err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+
+	page := models.ProductPage{Items: make([]models.Product, 0, len(raw)), Total: total}
+	for i, doc := range raw {
+		if i == query.Limit {
+			last := raw[i-1]
+			var value interface{}
+			if field != "_id" {
+				if v, err := last.LookupErr(strings.Split(field, ".")...); err == nil {
+					_ = v.Unmarshal(&value)
+				}
+			}
+			page.Next_Cursor = encodeCursor(value, page.Items[i-1].Product_ID)
+			break
+		}
+		var product models.Product
+		if err = bson.Unmarshal(doc, &product); err != nil {
+			log.Println(err)
+			return nil, ErrCantDecodeProducts
+		}
+		page.Items = append(page.Items, product)
+	}
+	return &page, nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MaxRecentlyViewed = 50
+	// Products kept per product in the precomputed recommendations
+	MaxRecommendations = 20
+)
+
+var ErrCantBuildRecommendations = errors.New("cannot build the recommendations")
+
+// RecordProductView remembers that the user looked at the product, viewing it
+// again moves it back to the front
+func RecordProductView(ctx context.Context, viewCollection *mongo.Collection, userID string, productID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	filter := bson.M{"user_id": id, "product_id": productID}
+	update := bson.M{"$set": bson.M{"viewed_at": time.Now()}, "$inc": bson.M{"views": 1}}
+	_, err = viewCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// RecentlyViewed returns the products the user viewed last, most recent first.
+// Products deleted since are left out.
+func RecentlyViewed(ctx context.Context, viewCollection, prodCollection *mongo.Collection, userID string, limit int) ([]models.Product, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, ErrUserIdIsNotValid
+	}
+	if limit <= 0 || limit > MaxRecentlyViewed {
+		limit = DefaultPageSize
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": id}}},
+		{{Key: "$sort", Value: bson.M{"viewed_at": -1}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         prodCollection.Name(),
+			"localField":   "product_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$product"}}},
+	}
+	return aggregateProducts(ctx, viewCollection, pipeline)
+}
+
+// Recommendations returns what customers who bought the product also bought,
+// from the collection BuildRecommendations fills
+func Recommendations(ctx context.Context, recommendationCollection, prodCollection *mongo.Collection, productID primitive.ObjectID, limit int) ([]models.Product, error) {
+	if limit <= 0 || limit > MaxRecommendations {
+		limit = MaxRecommendations
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": productID}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$also_bought", "includeArrayIndex": "rank"}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         prodCollection.Name(),
+			"localField":   "also_bought.product_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$sort", Value: bson.M{"rank": 1}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$product"}}},
+	}
+	return aggregateProducts(ctx, recommendationCollection, pipeline)
+}
+
+func aggregateProducts(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) ([]models.Product, error) {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, ErrCantDecodeProducts
+	}
+	return products, nil
+}
+
+// BuildRecommendations counts how often two products were ordered together and
+// replaces the recommendations collection with each product's most frequent
+// companions. $out swaps the collection in at the end, readers never see a half
+// built one.
+func BuildRecommendations(ctx context.Context, userCollection, recommendationCollection *mongo.Collection) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"orders.0": bson.M{"$exists": true}}}},
+		{{Key: "$unwind", Value: "$orders"}},
+		{{Key: "$match", Value: bson.M{"orders.status": bson.M{"$ne": models.OrderStatusCancelled}}}},
+		// $setUnion drops a product listed twice in the same order
+		{{Key: "$project", Value: bson.M{"_id": 0, "products": bson.M{"$setUnion": bson.A{"$orders.order_cart._id", bson.A{}}}}}},
+		{{Key: "$match", Value: bson.M{"products.1": bson.M{"$exists": true}}}},
+		{{Key: "$project", Value: bson.M{"product": "$products", "other": "$products"}}},
+		{{Key: "$unwind", Value: "$product"}},
+		{{Key: "$unwind", Value: "$other"}},
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$ne": bson.A{"$product", "$other"}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"product": "$product", "other": "$other"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.product", Value: 1}, {Key: "count", Value: -1}, {Key: "_id.other", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$_id.product",
+			"also_bought": bson.M{"$push": bson.M{"product_id": "$_id.other", "count": "$count"}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"also_bought": bson.M{"$slice": bson.A{"$also_bought", MaxRecommendations}},
+			"computed_at": "$$NOW",
+		}}},
+		{{Key: "$out", Value: recommendationCollection.Name()}},
+	}
+
+	cursor, err := userCollection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		log.Println(err)
+		return ErrCantBuildRecommendations
+	}
+	return cursor.Close(ctx)
+}
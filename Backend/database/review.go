@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotVerifiedPurchase = errors.New("only customers with a delivered order of this product can review it")
+	ErrReviewExists        = errors.New("you have already reviewed this product")
+	ErrReviewNotFound      = errors.New("review not found")
+	ErrInvalidReviewStatus = errors.New("status must be pending, approved or rejected")
+	ErrCantUpdateReview    = errors.New("cannot update the review")
+)
+
+// CreateReview stores the review as pending moderation. The user must have a
+// delivered order containing the product and can review each product once.
+func CreateReview(ctx context.Context, reviewCollection, userCollection *mongo.Collection, review *models.Review) error {
+	userID, err := primitive.ObjectIDFromHex(review.User_ID)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	order, err := deliveredOrderWith(ctx, userCollection, userID, review.Product_ID)
+	if err != nil {
+		return err
+	}
+
+	review.Review_ID = primitive.NewObjectID()
+	review.Order_ID = order.Order_ID
+	if user.First_Name != nil {
+		review.Author = *user.First_Name
+	}
+	review.Status = models.ReviewStatusPending
+	review.Moderation_Note = ""
+	review.Created_At = time.Now()
+	review.Updated_At = time.Now()
+
+	_, err = reviewCollection.InsertOne(ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrReviewExists
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateReview
+	}
+	return nil
+}
+
+// ReviewQuery selects the reviews ListReviews pages through
+type ReviewQuery struct {
+	Status     string
+	Product_ID *primitive.ObjectID // nil lists every product's reviews
+	Cursor     string
+	Limit      int
+}
+
+// ListReviews returns a page of reviews newest first, pass Next_Cursor back for the next one
+func ListReviews(ctx context.Context, reviewCollection *mongo.Collection, query ReviewQuery) (*models.ReviewPage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	filter := bson.M{"status": query.Status}
+	if query.Product_ID != nil {
+		filter["product_id"] = *query.Product_ID
+	}
+	if query.Cursor != "" {
+		before, err := primitive.ObjectIDFromHex(query.Cursor)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(limit + 1))
+	results, err := reviewCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	defer results.Close(ctx)
+
+	page := models.ReviewPage{Items: make([]models.Review, 0)}
+	if err = results.All(ctx, &page.Items); err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.Next_Cursor = page.Items[limit-1].Review_ID.Hex()
+	}
+	return &page, nil
+}
+
+// ModerateReview sets the review status and refreshes the product rating
+func ModerateReview(ctx context.Context, reviewCollection, prodCollection *mongo.Collection, id primitive.ObjectID, status string, note string) (*models.Review, error) {
+	switch status {
+	case models.ReviewStatusPending, models.ReviewStatusApproved, models.ReviewStatusRejected:
+	default:
+		return nil, ErrInvalidReviewStatus
+	}
+
+	update := bson.M{"$set": bson.M{"status": status, "moderation_note": note, "updated_at": time.Now()}}
+	findOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var review models.Review
+	err := reviewCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, findOptions).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrReviewNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateReview
+	}
+
+	if err = RefreshProductRating(ctx, reviewCollection, prodCollection, review.Product_ID); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func DeleteReview(ctx context.Context, reviewCollection, prodCollection *mongo.Collection, id primitive.ObjectID) error {
+	var review models.Review
+	err := reviewCollection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&review)
+	if err == mongo.ErrNoDocuments {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateReview
+	}
+	return RefreshProductRating(ctx, reviewCollection, prodCollection, review.Product_ID)
+}
+
+// RefreshProductRating recomputes the product's average rating and review count
+// from its approved reviews. Recomputing instead of incrementing keeps the numbers
+// right when moderation changes its mind.
+func RefreshProductRating(ctx context.Context, reviewCollection, prodCollection *mongo.Collection, productID primitive.ObjectID) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productID, "status": models.ReviewStatusApproved}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    nil,
+			"rating": bson.M{"$avg": "$rating"},
+			"count":  bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := reviewCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateReview
+	}
+	defer cursor.Close(ctx)
+
+	var totals []struct {
+		Rating float64 `bson:"rating"`
+		Count  int     `bson:"count"`
+	}
+	if err = cursor.All(ctx, &totals); err != nil {
+		log.Println(err)
+		return ErrCantUpdateReview
+	}
+
+	// Products without approved reviews have no rating rather than a rating of zero
+	set := bson.M{"rating": nil, "review_count": 0}
+	if len(totals) > 0 {
+		set["rating"] = float64(int(totals[0].Rating*10+0.5)) / 10
+		set["review_count"] = totals[0].Count
+	}
+	_, err = prodCollection.UpdateOne(ctx, bson.M{"_id": productID}, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateReview
+	}
+	return nil
+}
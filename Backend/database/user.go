@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NormalizeEmail is the form emails are stored and looked up in, so the
+// unique index treats addresses that differ only in case as one
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// DuplicateUsers are accounts sharing an email or phone
+type DuplicateUsers struct {
+	Field    string   `json:"field" bson:"-"`
+	Value    string   `json:"value" bson:"_id"`
+	User_IDs []string `json:"user_ids" bson:"user_ids"`
+}
+
+// FindDuplicateUsers lists the emails, compared case insensitively, and the
+// phones used by more than one user. They have to be merged or changed by
+// hand before the unique indexes can be built.
+func FindDuplicateUsers(ctx context.Context, userCollection *mongo.Collection) ([]DuplicateUsers, error) {
+	fields := []struct {
+		name string
+		key  interface{}
+	}{
+		{"email", bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}},
+		{"phone", "$phone"},
+	}
+
+	duplicates := make([]DuplicateUsers, 0)
+	for _, field := range fields {
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: bson.M{field.name: bson.M{"$type": "string"}}}},
+			{{Key: "$group", Value: bson.M{"_id": field.key, "user_ids": bson.M{"$push": bson.M{"$toString": "$_id"}}, "count": bson.M{"$sum": 1}}}},
+			{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+			{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		}
+		cursor, err := userCollection.Aggregate(ctx, pipeline)
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantGetItem
+		}
+		var found []DuplicateUsers
+		if err = cursor.All(ctx, &found); err != nil {
+			log.Println(err)
+			return nil, ErrCantGetItem
+		}
+		for _, duplicate := range found {
+			duplicate.Field = field.name
+			duplicates = append(duplicates, duplicate)
+		}
+	}
+	return duplicates, nil
+}
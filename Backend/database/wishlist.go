@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotInWishlist = errors.New("product is not in the wishlist")
+	ErrNotInCart     = errors.New("product is not in the cart")
+)
+
+// PriceDrop is a wishlisted product that got cheaper than the price the customer last saw
+type PriceDrop struct {
+	User_ID      primitive.ObjectID `bson:"user_id"`
+	Email        string             `bson:"email"`
+	First_Name   string             `bson:"first_name"`
+	Product_ID   primitive.ObjectID `bson:"product_id"`
+	Product_Name string             `bson:"product_name"`
+	Old_Price    models.Money       `bson:"old_price"`
+	New_Price    models.Money       `bson:"new_price"`
+}
+
+// AddToWishlist saves the product with its current price. Saving a product that
+// is already on the list changes nothing.
+func AddToWishlist(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	var product models.Product
+	err = prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return ErrCantFindProoduct
+	}
+	if err != nil {
+		log.Println(err)
+		return ErrCantDecodeProducts
+	}
+
+	item := models.WishlistItem{
+		Product_ID:   product.Product_ID,
+		Product_Name: product.Product_Name,
+		Image:        product.Image,
+		Added_At:     time.Now(),
+	}
+	if product.Price != nil {
+		item.Price = *product.Price
+	}
+
+	filter := bson.M{"_id": id, "wishlist._id": bson.M{"$ne": productID}}
+	_, err = userCollection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"wishlist": item}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+func RemoveFromWishlist(ctx context.Context, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$pull": bson.M{"wishlist": bson.M{"_id": productID}}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.ModifiedCount == 0 {
+		return ErrNotInWishlist
+	}
+	return nil
+}
+
+func Wishlist(ctx context.Context, userCollection *mongo.Collection, userID string) ([]models.WishlistItem, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+
+	var user models.User
+	findOptions := options.FindOne().SetProjection(bson.M{"wishlist": 1})
+	err = userCollection.FindOne(ctx, bson.M{"_id": id}, findOptions).Decode(&user)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+	if user.Wishlist == nil {
+		user.Wishlist = make([]models.WishlistItem, 0)
+	}
+	return user.Wishlist, nil
+}
+
+// MoveToCart adds a wishlisted product to the cart and takes it off the wishlist
+func MoveToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, quantity int) error {
+	if err := requireListed(ctx, userCollection, userID, "wishlist._id", productID, ErrNotInWishlist); err != nil {
+		return err
+	}
+	if _, err := AddProductToCart(ctx, prodCollection, userCollection, productID, userID, quantity); err != nil {
+		return err
+	}
+	return RemoveFromWishlist(ctx, userCollection, productID, userID)
+}
+
+// SaveForLater moves a product from the cart to the wishlist
+func SaveForLater(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+	if err := requireListed(ctx, userCollection, userID, "usercart._id", productID, ErrNotInCart); err != nil {
+		return err
+	}
+	if err := AddToWishlist(ctx, prodCollection, userCollection, productID, userID); err != nil {
+		return err
+	}
+	return RemoveCartItem(ctx, prodCollection, userCollection, productID, userID)
+}
+
+// requireListed returns missing unless the user has the product at the path
+func requireListed(ctx context.Context, userCollection *mongo.Collection, userID string, path string, productID primitive.ObjectID, missing error) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+	count, err := userCollection.CountDocuments(ctx, bson.M{"_id": id, path: productID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantGetItem
+	}
+	if count == 0 {
+		return missing
+	}
+	return nil
+}
+
+// FindPriceDrops compares wishlists with the current product prices. With a
+// product id only that product is checked, otherwise every wishlist is.
+func FindPriceDrops(ctx context.Context, userCollection, prodCollection *mongo.Collection, productID *primitive.ObjectID) ([]PriceDrop, error) {
+	match := bson.M{"wishlist.0": bson.M{"$exists": true}}
+	if productID != nil {
+		match = bson.M{"wishlist._id": *productID}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$wishlist"}},
+	}
+	if productID != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"wishlist._id": *productID}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         prodCollection.Name(),
+			"localField":   "wishlist._id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		bson.D{{Key: "$unwind", Value: "$product"}},
+		bson.D{{Key: "$match", Value: bson.M{"$expr": bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$product.price.currency", "$wishlist.price.currency"}},
+			bson.M{"$lt": bson.A{"$product.price.amount", "$wishlist.price.amount"}},
+		}}}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"user_id":      "$_id",
+			"email":        "$email",
+			"first_name":   "$first_name",
+			"product_id":   "$product._id",
+			"product_name": "$product.product_name",
+			"old_price":    "$wishlist.price",
+			"new_price":    "$product.price",
+		}}},
+	)
+
+	cursor, err := userCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	defer cursor.Close(ctx)
+
+	drops := make([]PriceDrop, 0)
+	if err = cursor.All(ctx, &drops); err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+	return drops, nil
+}
+
+// ClaimPriceDrop moves the wishlist price down to the one the customer is about
+// to be told about, so the next notification needs a further drop. It only
+// moves a price that is still the old one, so when several instances find the
+// same drop exactly one of them gets it. false means another instance did.
+func ClaimPriceDrop(ctx context.Context, userCollection *mongo.Collection, drop PriceDrop) (bool, error) {
+	return moveWishlistPrice(ctx, userCollection, drop, drop.Old_Price, drop.New_Price)
+}
+
+// ReleasePriceDrop puts back the old price of a claimed drop that couldn't be
+// queued, so the next sweep finds it again
+func ReleasePriceDrop(ctx context.Context, userCollection *mongo.Collection, drop PriceDrop) error {
+	_, err := moveWishlistPrice(ctx, userCollection, drop, drop.New_Price, drop.Old_Price)
+	return err
+}
+
+func moveWishlistPrice(ctx context.Context, userCollection *mongo.Collection, drop PriceDrop, from, to models.Money) (bool, error) {
+	filter := bson.M{"_id": drop.User_ID, "wishlist": bson.M{"$elemMatch": bson.M{
+		"_id":            drop.Product_ID,
+		"price.amount":   from.Amount,
+		"price.currency": from.Currency,
+	}}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"wishlist.$.price": to}})
+	if err != nil {
+		log.Println(err)
+		return false, ErrCantUpdateUser
+	}
+	return result.ModifiedCount == 1, nil
+}
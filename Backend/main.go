@@ -1,15 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Bhanubpsn/e-commerce-backend/broker"
+	"github.com/Bhanubpsn/e-commerce-backend/catalog"
 	"github.com/Bhanubpsn/e-commerce-backend/controllers"
 	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/media"
 	"github.com/Bhanubpsn/e-commerce-backend/middleware"
+	"github.com/Bhanubpsn/e-commerce-backend/migrate"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/notify"
+	"github.com/Bhanubpsn/e-commerce-backend/payment"
+	"github.com/Bhanubpsn/e-commerce-backend/postal"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
+	"github.com/Bhanubpsn/e-commerce-backend/recommend"
+	"github.com/Bhanubpsn/e-commerce-backend/repository"
 	"github.com/Bhanubpsn/e-commerce-backend/routes"
+	"github.com/Bhanubpsn/e-commerce-backend/search"
+	"github.com/Bhanubpsn/e-commerce-backend/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -20,15 +35,138 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	if currency := os.Getenv("CURRENCY"); currency != "" {
+		models.DefaultCurrency = strings.ToUpper(currency)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	app := controllers.NewApplication(
-		database.ProductData(database.Client, "Products"),
-		database.UserData(database.Client, "Users"),
+	client := database.DBSet()
+
+	migrations := migrate.NewRunner(client.Database("Ecommerce"), migrate.All)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := migrate.Command(context.Background(), migrations, os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "indexes":
+			// Only reports, missing indexes are created when the server starts
+			report, err := database.ReconcileIndexes(context.Background(), client.Database("Ecommerce"), database.Indexes, true)
+			if err != nil {
+				log.Fatal(err)
+			}
+			report.Write(os.Stdout)
+			return
+		case "duplicate-users":
+			// Users sharing an email or phone keep the unique indexes from being built
+			duplicates, err := database.FindDuplicateUsers(context.Background(), database.UserData(client, "Users"))
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, duplicate := range duplicates {
+				fmt.Printf("%s %q: users %s\n", duplicate.Field, duplicate.Value, strings.Join(duplicate.User_IDs, ", "))
+			}
+			fmt.Printf("%d duplicated emails and phones\n", len(duplicates))
+			return
+		}
+	}
+	// Every instance migrates at startup, the lock makes the others wait until
+	// the first one is done
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		if _, err := migrations.Up(context.Background()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Indexes come after the migrations, a unique index may need the data fixed first.
+	// INDEX_DRY_RUN=true only reports what is missing.
+	indexes, err := database.ReconcileIndexes(context.Background(), client.Database("Ecommerce"), database.Indexes, os.Getenv("INDEX_DRY_RUN") == "true")
+	if err != nil {
+		log.Println("Warning: Could not check indexes:", err)
+	} else {
+		indexes.Write(os.Stdout)
+	}
+
+	suggester := search.NewTrieSuggester(
+		database.ProductData(client, "Products"),
+		database.SearchData(client, "SearchTerms"),
+	)
+	go suggester.Run(context.Background())
+
+	imageStore := storage.FromEnv()
+
+	importer := catalog.NewImporter(
+		database.ProductData(client, "Products"),
+		database.CategoryData(client, "Categories"),
+		database.ImportData(client, "ImportJobs"),
+		client.Database("Ecommerce"),
+		broker.FromEnv(),
+	)
+	go importer.Run(context.Background())
+
+	priceDrops := notify.NewPriceDropWatcher(
+		database.ProductData(client, "Products"),
+		database.UserData(client, "Users"),
+		broker.FromEnv(),
+	)
+	go priceDrops.Run(context.Background())
+
+	cartReminder := notify.NewCartReminder(
+		database.UserData(client, "Users"),
+		broker.FromEnv(),
+		notify.CartReminderConfigFromEnv(),
 	)
+	go cartReminder.Run(context.Background())
+
+	recommendations := recommend.FromEnv(
+		database.UserData(client, "Users"),
+		database.RecommendationData(client, "Recommendations"),
+	)
+	go recommendations.Run(context.Background())
+
+	webhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		log.Fatal("PAYMENT_WEBHOOK_SECRET is not set, payment webhooks can't be verified without it")
+	}
+
+	products := database.ProductData(client, "Products")
+	users := database.UserData(client, "Users")
+	payments := database.PaymentData(client, "Payments")
+	coupons := database.CouponData(client, "Coupons")
+	redemptions := database.RedemptionData(client, "CouponRedemptions")
+	categories := database.CategoryData(client, "Categories")
+	paymentProvider := payment.NewFakeProvider(webhookSecret)
+	pricer := pricing.FromEnv()
+
+	app := controllers.NewApplication(controllers.Dependencies{
+		Users:           repository.NewMongoUserRepository(users),
+		Products:        repository.NewMongoProductRepository(products),
+		Orders:          repository.NewMongoOrderRepository(users),
+		Checkout:        repository.NewMongoCheckoutRepository(products, users, payments, coupons, redemptions, paymentProvider, pricer),
+		Carts:           repository.NewMongoCartRepository(products, users),
+		GuestCarts:      repository.NewMongoGuestCartRepository(products, database.GuestCartData(client, "GuestCarts"), users),
+		Wishlists:       repository.NewMongoWishlistRepository(products, users),
+		Addresses:       repository.NewMongoAddressRepository(users),
+		Coupons:         repository.NewMongoCouponRepository(coupons, redemptions, users, pricer),
+		Categories:      repository.NewMongoCategoryRepository(categories, products),
+		Reviews:         repository.NewMongoReviewRepository(database.ReviewData(client, "Reviews"), users, products),
+		Payments:        repository.NewMongoPaymentRepository(payments, paymentProvider),
+		Imports:         repository.NewMongoImportJobRepository(database.ImportData(client, "ImportJobs")),
+		Recommendations: repository.NewMongoRecommendationRepository(database.ProductViewData(client, "ProductViews"), database.RecommendationData(client, "Recommendations"), products),
+		PaymentProvider: paymentProvider,
+		Searcher:        search.NewMongoService(products),
+		Suggester:       suggester,
+		ImageStore:      imageStore,
+		ImageProcessor:  media.FromEnv(),
+		Importer:        importer,
+		CartReminder:    cartReminder,
+		AddressChecker:  postal.FromEnv(),
+	})
 
 	router := gin.New()
 	router.Use(gin.Logger())
@@ -44,13 +182,64 @@ func main() {
 		)
 	}))
 
-	routes.UserRoutes(router)
+	idempotent := middleware.Idempotency(database.IdempotencyData(client, "IdempotencyKeys"))
+	routes.UserRoutes(router, app, idempotent)
+	if local, ok := imageStore.(*storage.LocalStore); ok {
+		router.Static(local.URL_Prefix, local.Dir)
+	}
+	router.POST("/payments/webhook", idempotent, app.PaymentWebhook())
 	router.Use(middleware.Authentication())
+	router.Use(idempotent)
+
+	router.POST("/cart/items", app.AddCartItem())
+	router.DELETE("/cart/items/:id", app.DeleteCartItem())
+	router.POST("/cart/coupon", app.ApplyCoupon())
+	router.POST("/cart/items/:id/save-for-later", app.SaveForLater())
+	router.GET("/wishlist", app.GetWishlist())
+	router.POST("/wishlist/items", app.AddWishlistItem())
+	router.DELETE("/wishlist/items/:id", app.DeleteWishlistItem())
+	router.POST("/wishlist/items/:id/move-to-cart", app.MoveToCart())
+	router.PUT("/users/preferences", app.UpdatePreferences())
+	router.GET("/users/me/recently-viewed", app.RecentlyViewed())
+	router.GET("/addresses", app.ListAddresses())
+	router.POST("/addresses", app.AddAddress())
+	router.PUT("/addresses/:id", app.UpdateAddress())
+	router.DELETE("/addresses/:id", app.DeleteAddress())
+	router.GET("/orders", app.ListOrders())
+	router.POST("/orders", app.PlaceOrder())
+	router.POST("/products/:id/reviews", app.CreateReview())
+
+	// The old GET routes let crawlers and prefetchers place orders, they are only kept
+	// around for clients that have not moved to the routes above yet
+	if os.Getenv("ENABLE_LEGACY_GET_ROUTES") == "true" {
+		router.GET("/addtocart", middleware.Deprecated("POST /cart/items"), app.AddToCart())
+		router.GET("/removeitem", middleware.Deprecated("DELETE /cart/items/:id"), app.RemoveItem())
+		router.GET("/cartcheckout", middleware.Deprecated("POST /orders"), app.BuyFromCart())
+		router.GET("/instantbuy", middleware.Deprecated("POST /orders"), app.InstantBuy())
+	}
 
-	router.GET("/addtocart", app.AddToCart())
-	router.GET("/removeitem", app.RemoveItem())
-	router.GET("/cartcheckout", app.BuyFromCart())
-	router.GET("/instantbuy", app.InstantBuy())
+	// Checked on the role claim, the /admin prefix alone protects nothing
+	admin := router.Group("/admin", middleware.Admin())
+	admin.POST("/addproduct", app.ProductViewerAdmin())
+	admin.POST("/payments/refund", app.RefundPayment())
+	admin.POST("/coupons", app.CreateCoupon())
+	admin.GET("/coupons", app.ListCoupons())
+	admin.DELETE("/coupons/:code", app.DeleteCoupon())
+	admin.PUT("/orders/:id/status", app.UpdateOrderStatus())
+	admin.GET("/reviews", app.ListReviews())
+	admin.PUT("/reviews/:id", app.ModerateReview())
+	admin.DELETE("/reviews/:id", app.DeleteReview())
+	admin.POST("/categories", app.CreateCategory())
+	admin.PUT("/categories/:id", app.UpdateCategory())
+	admin.DELETE("/categories/:id", app.DeleteCategory())
+	admin.PUT("/products/:id/category", app.AssignProductCategory())
+	admin.POST("/products/:id/images", app.UploadProductImages())
+	admin.DELETE("/products/:id/images/:image_id", app.DeleteProductImage())
+	admin.PUT("/products/:id/images/:image_id/primary", app.SetPrimaryImage())
+	admin.POST("/products/import", app.ImportProducts())
+	admin.GET("/products/import/:id", app.ImportStatus())
+	admin.GET("/products/import/:id/errors", app.ImportErrors())
+	admin.GET("/products/export", app.ExportProducts())
 
 	log.Fatal(router.Run(":" + port))
 }
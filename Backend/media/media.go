@@ -0,0 +1,174 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var (
+	ErrUnsupportedType = errors.New("image must be a JPEG, PNG or GIF")
+	ErrImageTooLarge   = errors.New("image is too large")
+	ErrInvalidImage    = errors.New("image could not be decoded")
+)
+
+const (
+	DefaultMaxBytes      = 5 << 20
+	DefaultThumbnailSize = 320
+	// Decoding allocates width*height*4 bytes, this keeps a tiny file from
+	// claiming a huge canvas and eating the server's memory
+	maxPixels = 40_000_000
+)
+
+// Extensions are the content types accepted for upload, keyed to the file
+// extension they are stored with
+var Extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Image is an upload that passed validation together with its thumbnail
+type Image struct {
+	Data                   []byte
+	Content_Type           string
+	Extension              string
+	Width                  int
+	Height                 int
+	Thumbnail              []byte
+	Thumbnail_Content_Type string
+	Thumbnail_Extension    string
+}
+
+type Processor struct {
+	Max_Bytes      int64
+	Thumbnail_Size int // longest side of the thumbnail in pixels
+}
+
+// FromEnv reads MAX_IMAGE_BYTES and THUMBNAIL_SIZE
+func FromEnv() Processor {
+	return Processor{
+		Max_Bytes:      int64(envInt("MAX_IMAGE_BYTES", DefaultMaxBytes)),
+		Thumbnail_Size: envInt("THUMBNAIL_SIZE", DefaultThumbnailSize),
+	}
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Warning: %s must be a positive number, using %d", key, fallback)
+		return fallback
+	}
+	return n
+}
+
+// Prepare checks the upload and renders its thumbnail. The content type is
+// sniffed from the bytes, whatever the client claimed is ignored.
+func (p Processor) Prepare(data []byte) (*Image, error) {
+	if int64(len(data)) > p.Max_Bytes {
+		return nil, ErrImageTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	extension, ok := Extensions[contentType]
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	upload := &Image{
+		Data:         data,
+		Content_Type: contentType,
+		Extension:    extension,
+		Width:        config.Width,
+		Height:       config.Height,
+	}
+
+	// JPEGs stay JPEG, everything else may be transparent so it becomes PNG
+	thumbnail := Thumbnail(source, p.Thumbnail_Size)
+	var encoded bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&encoded, thumbnail, &jpeg.Options{Quality: 80})
+		upload.Thumbnail_Content_Type, upload.Thumbnail_Extension = "image/jpeg", ".jpg"
+	} else {
+		err = png.Encode(&encoded, thumbnail)
+		upload.Thumbnail_Content_Type, upload.Thumbnail_Extension = "image/png", ".png"
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrInvalidImage
+	}
+	upload.Thumbnail = encoded.Bytes()
+	return upload, nil
+}
+
+// Thumbnail scales the image down so its longest side is at most size pixels,
+// averaging the source pixels that fall into each thumbnail pixel. Images that
+// are already small enough keep their size.
+func Thumbnail(source image.Image, size int) *image.RGBA {
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	targetWidth, targetHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			targetWidth, targetHeight = size, max(1, height*size/width)
+		} else {
+			targetWidth, targetHeight = max(1, width*size/height), size
+		}
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Bounds(), source, bounds.Min, draw.Src)
+	if targetWidth == width && targetHeight == height {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0, y1 := y*height/targetHeight, max((y+1)*height/targetHeight, y*height/targetHeight+1)
+		for x := 0; x < targetWidth; x++ {
+			x0, x1 := x*width/targetWidth, max((x+1)*width/targetWidth, x*width/targetWidth+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += int(pixel[0])
+					g += int(pixel[1])
+					b += int(pixel[2])
+					a += int(pixel[3])
+					n++
+				}
+			}
+			offset := y*dst.Stride + x*4
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,123 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func solid(width, height int, fill color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, fill)
+		}
+	}
+	return img
+}
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		size          int
+		wantW, wantH  int
+	}{
+		{"already small", 200, 100, 320, 200, 100},
+		{"exactly the size", 320, 200, 320, 320, 200},
+		{"thin strip keeps a pixel", 2000, 1, 320, 320, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thumbnail := Thumbnail(solid(tt.width, tt.height, color.White), tt.size)
+			bounds := thumbnail.Bounds()
+			if bounds.Dx() != tt.wantW || bounds.Dy() != tt.wantH {
+				t.Errorf("thumbnail is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantW, tt.wantH)
+			}
+			if bounds.Min != (image.Point{}) {
+				t.Errorf("thumbnail starts at %v, want the origin", bounds.Min)
+			}
+		})
+	}
+}
+
+func TestThumbnailAveragesPixels(t *testing.T) {
+	// Alternating black and white columns average to grey
+	source := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x%2 == 0 {
+				source.Set(x, y, color.Black)
+			} else {
+				source.Set(x, y, color.White)
+			}
+		}
+	}
+	thumbnail := Thumbnail(source, 2)
+	got := thumbnail.RGBAAt(0, 0)
+	if got.R < 120 || got.R > 135 || got.R != got.G || got.G != got.B {
+		t.Errorf("pixel = %v, want a mid grey", got)
+	}
+}
+
+func TestThumbnailOffsetBounds(t *testing.T) {
+	source := solid(800, 400, color.RGBA{R: 255, A: 255}).SubImage(image.Rect(100, 100, 500, 300))
+	thumbnail := Thumbnail(source, 100)
+	if bounds := thumbnail.Bounds(); bounds.Dx() != 100 || bounds.Dy() != 50 {
+		t.Fatalf("thumbnail is %dx%d, want 100x50", bounds.Dx(), bounds.Dy())
+	}
+	if got := thumbnail.RGBAAt(50, 25); got.R != 255 || got.G != 0 {
+		t.Errorf("pixel = %v, want red", got)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	var pngData, jpegData bytes.Buffer
+	if err := png.Encode(&pngData, solid(640, 480, color.White)); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, solid(640, 480, color.White), nil); err != nil {
+		t.Fatal(err)
+	}
+	processor := Processor{Max_Bytes: DefaultMaxBytes, Thumbnail_Size: 160}
+
+	tests := []struct {
+		name          string
+		data          []byte
+		processor     Processor
+		err           error
+		thumbnailType string
+	}{
+		{"jpeg", jpegData.Bytes(), processor, nil, "image/jpeg"},
+		{"too many bytes", pngData.Bytes(), Processor{Max_Bytes: 10, Thumbnail_Size: 160}, ErrImageTooLarge, ""},
+		{"not an image", []byte("hello, this is text"), processor, ErrUnsupportedType, ""},
+		{"truncated png", pngData.Bytes()[:40], processor, ErrInvalidImage, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload, err := tt.processor.Prepare(tt.data)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if upload.Width != 640 || upload.Height != 480 {
+				t.Errorf("size = %dx%d, want 640x480", upload.Width, upload.Height)
+			}
+			if upload.Thumbnail_Content_Type != tt.thumbnailType {
+				t.Errorf("thumbnail type = %q, want %q", upload.Thumbnail_Content_Type, tt.thumbnailType)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(upload.Thumbnail))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Width != 160 || config.Height != 120 {
+				t.Errorf("thumbnail is %dx%d, want 160x120", config.Width, config.Height)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks a legacy route, every hit is logged with the caller so we can
+// tell when it is safe to drop the route entirely.
+func Deprecated(replacement string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log.Printf("[DEPRECATED] %s %s used by uid=%s ip=%s, use %s instead",
+			c.Request.Method,
+			c.Request.URL.Path,
+			c.GetString("uid"),
+			c.ClientIP(),
+			replacement,
+		)
+		c.Header("Deprecation", "true")
+		c.Header("Warning", "299 - \"Deprecated API, use "+replacement+" instead\"")
+		c.Next()
+	}
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Stored responses are replayed for this long, the TTL index on created_at uses the same window
+const IdempotencyWindow = 24 * time.Hour
+
+// IdempotencyLease is how long a request holds its key while the handler runs.
+// A retry after the lease ran out takes the key over, so a crash mid request
+// doesn't leave the key stuck answering 409.
+const IdempotencyLease = 2 * time.Minute
+
+// Public routes have no user, keys sent with a guest cart token are scoped to it
+const cartTokenHeader = "X-Cart-Token"
+
+const (
+	idempotencyProcessing = "processing"
+	idempotencyCompleted  = "completed"
+)
+
+type idempotencyRecord struct {
+	ID           string    `bson:"_id"`
+	User_ID      string    `bson:"user_id"`
+	Key          string    `bson:"key"`
+	Fingerprint  string    `bson:"fingerprint"`
+	Status       string    `bson:"status"`
+	Owner        string    `bson:"owner"`
+	Lease_Until  time.Time `bson:"lease_until"`
+	Status_Code  int       `bson:"status_code"`
+	Content_Type string    `bson:"content_type"`
+	Body         []byte    `bson:"body"`
+	Created_At   time.Time `bson:"created_at"`
+}
+
+// responseRecorder keeps a copy of everything the handler writes so it can be cached
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+func fingerprint(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "?" + c.Request.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotency honours the Idempotency-Key header. The first request with a key is
+// executed and its response stored, retries with the same key and the same request
+// get the stored response back instead of running the handler again.
+// Keys are scoped per user on authenticated routes, so it must come after
+// Authentication there. Public routes attach it per route.
+func Idempotency(collection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		uid := c.GetString("uid")
+		scope := uid
+		if scope == "" {
+			scope = "guest:" + c.GetHeader(cartTokenHeader)
+		}
+		now := time.Now()
+		record := idempotencyRecord{
+			ID:          scope + ":" + key,
+			User_ID:     uid,
+			Key:         key,
+			Fingerprint: fingerprint(c, body),
+			Status:      idempotencyProcessing,
+			Owner:       primitive.NewObjectID().Hex(),
+			Lease_Until: now.Add(IdempotencyLease),
+			Created_At:  now,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err = collection.InsertOne(ctx, record)
+		if mongo.IsDuplicateKeyError(err) {
+			var stored idempotencyRecord
+			if err = collection.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&stored); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load the stored response"})
+				c.Abort()
+				return
+			}
+
+			// The TTL monitor only runs once a minute, expired keys may still be around
+			expired := now.Sub(stored.Created_At) > IdempotencyWindow
+			// A request that stopped without finishing gives its key up once the lease runs out
+			abandoned := stored.Status == idempotencyProcessing && now.After(stored.Lease_Until) && stored.Fingerprint == record.Fingerprint
+			if !expired && !abandoned {
+				replay(c, &stored, record.Fingerprint)
+				return
+			}
+			// Matched on the owner so of two retries racing for the key only one gets it
+			filter := bson.M{"_id": record.ID, "owner": stored.Owner, "created_at": stored.Created_At}
+			if stored.Owner == "" {
+				// Stored before keys had owners
+				filter["owner"] = bson.M{"$exists": false}
+			}
+			result, err := collection.ReplaceOne(ctx, filter, record)
+			if err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not store the idempotency key"})
+				c.Abort()
+				return
+			}
+			if result.MatchedCount == 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+				c.Abort()
+				return
+			}
+		} else if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not store the idempotency key"})
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = recorder
+		c.Next()
+
+		saveCtx, saveCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer saveCancel()
+
+		// Only the request holding the key settles it, one whose lease was taken
+		// over leaves the record to the request that took it
+		owned := bson.M{"_id": record.ID, "owner": record.Owner}
+		// Server errors are not cached so the client can retry them with the same key
+		if recorder.Status() >= http.StatusInternalServerError {
+			_, err = collection.DeleteOne(saveCtx, owned)
+		} else {
+			update := bson.M{"$set": bson.M{
+				"status":       idempotencyCompleted,
+				"status_code":  recorder.Status(),
+				"content_type": recorder.Header().Get("Content-Type"),
+				"body":         recorder.body.Bytes(),
+			}}
+			_, err = collection.UpdateOne(saveCtx, owned, update)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func replay(c *gin.Context, stored *idempotencyRecord, fingerprint string) {
+	if stored.Fingerprint != fingerprint {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+		c.Abort()
+		return
+	}
+	if stored.Status != idempotencyCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+		c.Abort()
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(stored.Status_Code, stored.Content_Type, stored.Body)
+	c.Abort()
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/Bhanubpsn/e-commerce-backend/models"
 	token "github.com/Bhanubpsn/e-commerce-backend/token"
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +25,35 @@ func Authentication() gin.HandlerFunc {
 
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
+		c.Set("role", claims.Role)
+		c.Next()
+	}
+}
+
+// Admin lets through users whose token carries the admin role, it has to run
+// after Authentication
+func Admin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != models.RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin only"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// OptionalAuthentication sets email and uid when a valid token is sent and lets
+// anonymous requests through, for public routes that do more for signed in users
+func OptionalAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ClientToken := c.Request.Header.Get("token")
+		if ClientToken != "" {
+			if claims, err := token.ValidateToken(ClientToken); err == "" {
+				c.Set("email", claims.Email)
+				c.Set("uid", claims.Uid)
+			}
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	generate "github.com/Bhanubpsn/e-commerce-backend/token"
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminNeedsTheRoleClaim(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	adminToken, _, err := generate.TokenGenerator("admin@example.com", "Asha", "Rao", "1", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userToken, _, err := generate.TokenGenerator("user@example.com", "Ravi", "Kumar", "2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(Authentication())
+	router.GET("/admin/reviews", Admin(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/admin/reviews", nil)
+		request.Header.Set("token", token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response.Code
+	}
+	if code := request(userToken); code != http.StatusForbidden {
+		t.Errorf("customer got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := request(adminToken); code != http.StatusOK {
+		t.Errorf("admin got %d, want %d", code, http.StatusOK)
+	}
+}
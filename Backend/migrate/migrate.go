@@ -0,0 +1,332 @@
+// Package migrate applies versioned changes to the documents of the Ecommerce
+// database. Applied versions are recorded in the Migrations collection and a
+// lock document keeps two instances from migrating at the same time.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// A crashed instance holds the lock until it expires, a running one renews
+	// it every LockRenew so migrations may take longer than the TTL
+	LockTTL   = 15 * time.Minute
+	LockRenew = LockTTL / 3
+	LockRetry = 2 * time.Second
+	lockID    = "migrations"
+)
+
+var (
+	ErrDuplicateVersion = errors.New("two migrations have the same version")
+	ErrIrreversible     = errors.New("migration can't be rolled back")
+	ErrUnknownVersion   = errors.New("an applied migration is not known to this build")
+	ErrLocked           = errors.New("another instance is running migrations")
+	ErrLockLost         = errors.New("the migration lock could not be renewed")
+	ErrUsage            = errors.New("usage: migrate up | down [steps] | status")
+)
+
+// Migration changes the database from the previous version to Version. Up
+// should be safe to run again, it is repeated when recording it fails.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	// nil when the change can't be undone
+	Down func(ctx context.Context, db *mongo.Database) error
+}
+
+type record struct {
+	Version    int       `bson:"_id"`
+	Name       string    `bson:"name"`
+	Applied_At time.Time `bson:"applied_at"`
+}
+
+// Status is one line of the status command, Applied_At is nil while pending
+type Status struct {
+	Version    int        `json:"version"`
+	Name       string     `json:"name"`
+	Applied_At *time.Time `json:"applied_at"`
+}
+
+type Runner struct {
+	db         *mongo.Database
+	applied    *mongo.Collection
+	locks      *mongo.Collection
+	migrations []Migration
+}
+
+func NewRunner(db *mongo.Database, migrations []Migration) *Runner {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Runner{
+		db:         db,
+		applied:    db.Collection("Migrations"),
+		locks:      db.Collection("MigrationLocks"),
+		migrations: sorted,
+	}
+}
+
+// Up applies every pending migration in version order and returns how many ran
+func (r *Runner) Up(ctx context.Context) (int, error) {
+	ctx, release, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	applied, err := r.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range r.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		log.Printf("migrate: applying %d %s", migration.Version, migration.Name)
+		if err = migration.Up(ctx, r.db); err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, lockErr(ctx, err))
+		}
+		done := record{Version: migration.Version, Name: migration.Name, Applied_At: time.Now()}
+		if _, err = r.applied.InsertOne(ctx, done); err != nil {
+			return count, fmt.Errorf("recording migration %d: %w", migration.Version, lockErr(ctx, err))
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down rolls back the latest applied migrations, newest first. Every one of
+// them is checked before the first is rolled back, so an unknown or
+// irreversible version stops the command without changing anything.
+func (r *Runner) Down(ctx context.Context, steps int) (int, error) {
+	ctx, release, err := r.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	applied, err := r.appliedVersions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	known := make(map[int]Migration, len(r.migrations))
+	for _, migration := range r.migrations {
+		known[migration.Version] = migration
+	}
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	if len(versions) > steps {
+		versions = versions[:steps]
+	}
+
+	rollback := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		migration, ok := known[version]
+		if !ok {
+			return 0, fmt.Errorf("migration %d: %w", version, ErrUnknownVersion)
+		}
+		if migration.Down == nil {
+			return 0, fmt.Errorf("migration %d %s: %w", version, migration.Name, ErrIrreversible)
+		}
+		rollback = append(rollback, migration)
+	}
+
+	count := 0
+	for _, migration := range rollback {
+		log.Printf("migrate: rolling back %d %s", migration.Version, migration.Name)
+		if err = migration.Down(ctx, r.db); err != nil {
+			return count, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, lockErr(ctx, err))
+		}
+		if _, err = r.applied.DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return count, fmt.Errorf("recording rollback of %d: %w", migration.Version, lockErr(ctx, err))
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Status lists the known migrations and any applied ones this build doesn't have
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(r.migrations))
+	for _, migration := range r.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if done, ok := applied[migration.Version]; ok {
+			status.Applied_At = &done.Applied_At
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, done := range applied {
+		appliedAt := done.Applied_At
+		statuses = append(statuses, Status{Version: done.Version, Name: done.Name + " (unknown)", Applied_At: &appliedAt})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+func (r *Runner) appliedVersions(ctx context.Context) (map[int]record, error) {
+	for i := 1; i < len(r.migrations); i++ {
+		if r.migrations[i].Version == r.migrations[i-1].Version {
+			return nil, fmt.Errorf("version %d: %w", r.migrations[i].Version, ErrDuplicateVersion)
+		}
+	}
+
+	cursor, err := r.applied.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]record, len(records))
+	for _, done := range records {
+		applied[done.Version] = done
+	}
+	return applied, nil
+}
+
+// lock waits until no other instance holds the migration lock or ctx is done.
+// Taking it is an upsert that only matches an expired lock, so while another
+// instance holds it the insert fails on the _id. The lock is renewed until it
+// is released, the returned context is cancelled when a renewal fails so the
+// running migration stops before another instance can take over.
+func (r *Runner) lock(ctx context.Context) (lockCtx context.Context, release func(), err error) {
+	owner := primitive.NewObjectID().Hex()
+	for {
+		now := time.Now()
+		filter := bson.M{"_id": lockID, "expires_at": bson.M{"$lt": now}}
+		update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(LockTTL)}}
+		_, err = r.locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ErrLocked
+		case <-time.After(LockRetry):
+		}
+	}
+
+	lockCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(LockRenew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-lockCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			filter := bson.M{"_id": lockID, "owner": owner}
+			update := bson.M{"$set": bson.M{"expires_at": time.Now().Add(LockTTL)}}
+			result, err := r.locks.UpdateOne(lockCtx, filter, update)
+			if err == nil && result.MatchedCount == 0 {
+				err = errors.New("the lock was taken over")
+			}
+			if err != nil {
+				log.Println("migrate: renewing the lock:", err)
+				cancel(ErrLockLost)
+				return
+			}
+		}
+	}()
+
+	return lockCtx, func() {
+		close(done)
+		<-renewed
+		cancel(nil)
+		releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer releaseCancel()
+		if _, err := r.locks.DeleteOne(releaseCtx, bson.M{"_id": lockID, "owner": owner}); err != nil {
+			log.Println(err)
+		}
+	}, nil
+}
+
+// lockErr reports a lost lock rather than the cancellation it caused
+func lockErr(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrLockLost) {
+		return ErrLockLost
+	}
+	return err
+}
+
+// Migrator is what the migrate subcommand drives, Runner against the database
+type Migrator interface {
+	Up(ctx context.Context) (int, error)
+	Down(ctx context.Context, steps int) (int, error)
+	Status(ctx context.Context) ([]Status, error)
+}
+
+// Command runs the migrate subcommand with the arguments after "migrate"
+func Command(ctx context.Context, runner Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	switch args[0] {
+	case "up":
+		count, err := runner.Up(ctx)
+		fmt.Fprintf(out, "applied %d migrations\n", count)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return ErrUsage
+			}
+			steps = n
+		}
+		count, err := runner.Down(ctx, steps)
+		fmt.Fprintf(out, "rolled back %d migrations\n", count)
+		return err
+	case "status":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied_At != nil {
+				applied = status.Applied_At.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	}
+	return ErrUsage
+}
@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMigrator records what the command asked for
+type fakeMigrator struct {
+	upCount   int
+	downSteps int
+	statuses  []Status
+	err       error
+}
+
+func (f *fakeMigrator) Up(ctx context.Context) (int, error) {
+	return f.upCount, f.err
+}
+
+func (f *fakeMigrator) Down(ctx context.Context, steps int) (int, error) {
+	f.downSteps = steps
+	if f.err != nil {
+		return 0, f.err
+	}
+	return steps, nil
+}
+
+func (f *fakeMigrator) Status(ctx context.Context) ([]Status, error) {
+	return f.statuses, f.err
+}
+
+func TestCommand(t *testing.T) {
+	applied := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	failed := errors.New("boom")
+
+	tests := []struct {
+		name      string
+		args      []string
+		migrator  *fakeMigrator
+		err       error
+		output    []string
+		downSteps int
+	}{
+		{"no arguments", nil, &fakeMigrator{}, ErrUsage, nil, 0},
+		{"unknown command", []string{"sideways"}, &fakeMigrator{}, ErrUsage, nil, 0},
+		{"up fails", []string{"up"}, &fakeMigrator{upCount: 1, err: failed}, failed, []string{"applied 1 migrations"}, 0},
+		{"down one by default", []string{"down"}, &fakeMigrator{}, nil, []string{"rolled back 1 migrations"}, 1},
+		{"down zero steps", []string{"down", "0"}, &fakeMigrator{}, ErrUsage, nil, 0},
+		{"down not a number", []string{"down", "all"}, &fakeMigrator{}, ErrUsage, nil, 0},
+		{"down irreversible", []string{"down"}, &fakeMigrator{err: ErrIrreversible}, ErrIrreversible, []string{"rolled back 0 migrations"}, 1},
+		{"status", []string{"status"}, &fakeMigrator{statuses: []Status{
+			{Version: 1, Name: "money_prices", Applied_At: &applied},
+			{Version: 2, Name: "cart_quantity"},
+		}}, nil, []string{"VERSION", "1        money_prices   2026-01-02T03:04:05Z", "2        cart_quantity  pending"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Command(context.Background(), tt.migrator, tt.args, &out)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			for _, line := range tt.output {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("output %q does not contain %q", out.String(), line)
+				}
+			}
+			if tt.migrator.downSteps != tt.downSteps {
+				t.Errorf("rolled back %d steps, want %d", tt.migrator.downSteps, tt.downSteps)
+			}
+		})
+	}
+}
+
+func TestAllVersionsAreUnique(t *testing.T) {
+	seen := make(map[int]string)
+	for _, migration := range All {
+		if other, ok := seen[migration.Version]; ok {
+			t.Errorf("version %d is used by %s and %s", migration.Version, other, migration.Name)
+		}
+		seen[migration.Version] = migration.Name
+		if migration.Up == nil {
+			t.Errorf("migration %d %s has no Up", migration.Version, migration.Name)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package migrate
+
+import (
+	"context"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All is every migration of the Ecommerce database. Append new ones with the
+// next version, never renumber or edit one that has shipped.
+var All = []Migration{
+	{Version: 1, Name: "money_prices", Up: moneyPricesUp, Down: moneyPricesDown},
+	// A quantity of one is what a missing quantity meant, the carts that had
+	// none can't be told apart anymore
+	{Version: 2, Name: "cart_quantity", Up: cartQuantityUp, Down: nil},
+	// The original case of the emails is not kept, there is nothing to restore it from
+	{Version: 3, Name: "lowercase_emails", Up: lowercaseEmailsUp, Down: nil},
+	{Version: 4, Name: "coupon_redemptions", Up: couponRedemptionsUp, Down: couponRedemptionsDown},
+	{Version: 5, Name: "product_name_tokens", Up: productNameTokensUp, Down: productNameTokensDown},
+}
+
+// Prices used to be plain numbers in major units of the default currency. The
+// models still read them, this rewrites them as {amount, currency} documents.
+func moneyPricesUp(ctx context.Context, db *mongo.Database) error {
+	unit, err := models.FromMajor(1, models.DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	stored := func(field string) bson.M {
+		return bson.M{field: bson.M{"$type": "number"}}
+	}
+	legacy := func(value string) bson.M {
+		return bson.M{"$isNumber": value}
+	}
+	convert := func(value string) bson.M {
+		return bson.M{
+			"amount":   bson.M{"$toLong": bson.M{"$round": bson.A{bson.M{"$multiply": bson.A{value, unit.Amount}}, 0}}},
+			"currency": unit.Currency,
+		}
+	}
+	return rewritePrices(ctx, db, stored, legacy, convert)
+}
+
+func moneyPricesDown(ctx context.Context, db *mongo.Database) error {
+	unit, err := models.FromMajor(1, models.DefaultCurrency)
+	if err != nil {
+		return err
+	}
+	stored := func(field string) bson.M {
+		return bson.M{field + ".currency": unit.Currency}
+	}
+	current := func(value string) bson.M {
+		return bson.M{"$eq": bson.A{value + ".currency", unit.Currency}}
+	}
+	revert := func(value string) bson.M {
+		return bson.M{"$divide": bson.A{value + ".amount", unit.Amount}}
+	}
+	return rewritePrices(ctx, db, stored, current, revert)
+}
+
+// rewritePrices replaces the price of products, cart items and ordered items,
+// and the order totals. stored finds the documents holding a price to rewrite,
+// match picks those prices out inside them.
+func rewritePrices(ctx context.Context, db *mongo.Database, stored func(field string) bson.M, match func(value string) bson.M, rewrite func(value string) bson.M) error {
+	rewriteIf := func(value string) bson.M {
+		return bson.M{"$cond": bson.A{match(value), rewrite(value), value}}
+	}
+	rewriteItems := func(items string) bson.M {
+		return bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{items, bson.A{}}},
+			"as":    "item",
+			"in":    bson.M{"$mergeObjects": bson.A{"$$item", bson.M{"price": rewriteIf("$$item.price")}}},
+		}}
+	}
+
+	products := db.Collection("Products")
+	_, err := products.UpdateMany(ctx, stored("price"), mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"price": rewriteIf("$price")}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	users := db.Collection("Users")
+	userFilter := bson.M{"$or": bson.A{
+		stored("usercart.price"),
+		stored("orders.order_cart.price"),
+		stored("orders.price"),
+	}}
+	_, err = users.UpdateMany(ctx, userFilter, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"usercart": rewriteItems("$usercart"),
+			"orders": bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$orders", bson.A{}}},
+				"as":    "order",
+				"in": bson.M{"$mergeObjects": bson.A{"$$order", bson.M{
+					"order_cart": rewriteItems("$$order.order_cart"),
+					"price":      rewriteIf("$$order.price"),
+				}}},
+			}},
+		}}},
+	})
+	return err
+}
+
+// Cart items added before quantities existed have none, they were one each
+func cartQuantityUp(ctx context.Context, db *mongo.Database) error {
+	filter := bson.M{"usercart": bson.M{"$elemMatch": bson.M{"quantity": bson.M{"$not": bson.M{"$gte": 1}}}}}
+	_, err := db.Collection("Users").UpdateMany(ctx, filter, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"usercart": bson.M{"$map": bson.M{
+			"input": "$usercart",
+			"as":    "item",
+			"in": bson.M{"$mergeObjects": bson.A{"$$item", bson.M{
+				"quantity": bson.M{"$max": bson.A{1, bson.M{"$ifNull": bson.A{"$$item.quantity", 1}}}},
+			}}},
+		}}}}},
+	})
+	return err
+}
+
+// Emails are stored lowercased for the unique index. Users whose email only
+// differs in case from another user's are left alone, the duplicate-users
+// command lists them to be sorted out by hand.
+func lowercaseEmailsUp(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("Users")
+	cursor, err := users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"email": bson.M{"$type": "string"}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+			"ids":    bson.M{"$push": "$_id"},
+			"emails": bson.M{"$push": "$email"},
+		}}},
+		{{Key: "$match", Value: bson.M{"ids": bson.M{"$size": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	writes := make([]mongo.WriteModel, 0)
+	for cursor.Next(ctx) {
+		var group struct {
+			Email  string               `bson:"_id"`
+			IDs    []primitive.ObjectID `bson:"ids"`
+			Emails []string             `bson:"emails"`
+		}
+		if err = cursor.Decode(&group); err != nil {
+			return err
+		}
+		if group.Emails[0] == group.Email {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": group.IDs[0]}).
+			SetUpdate(bson.M{"$set": bson.M{"email": group.Email}}))
+	}
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err = users.BulkWrite(ctx, writes)
+	return err
+}
+
+// Redemptions used to be pushed onto the coupon document, they move to their
+// own collection so popular coupons stay small
+func couponRedemptionsUp(ctx context.Context, db *mongo.Database) error {
+	coupons := db.Collection("Coupons")
+	cursor, err := coupons.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"redemptions.0": bson.M{"$exists": true}}}},
+		{{Key: "$unwind", Value: "$redemptions"}},
+		{{Key: "$project", Value: bson.M{
+			"_id":         0,
+			"coupon_id":   "$_id",
+			"user_id":     "$redemptions.user_id",
+			"order_id":    "$redemptions.order_id",
+			"redeemed_at": "$redemptions.redeemed_at",
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var redemptions []bson.M
+	if err = cursor.All(ctx, &redemptions); err != nil {
+		return err
+	}
+
+	if len(redemptions) > 0 {
+		writes := make([]mongo.WriteModel, 0, len(redemptions))
+		for _, redemption := range redemptions {
+			filter := bson.M{"coupon_id": redemption["coupon_id"], "order_id": redemption["order_id"]}
+			redemption["_id"] = primitive.NewObjectID()
+			// Upserted on coupon and order so running it again adds nothing
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(filter).
+				SetUpdate(bson.M{"$setOnInsert": redemption}).
+				SetUpsert(true))
+		}
+		if _, err = db.Collection("CouponRedemptions").BulkWrite(ctx, writes); err != nil {
+			return err
+		}
+	}
+	_, err = coupons.UpdateMany(ctx, bson.M{"redemptions": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"redemptions": ""}})
+	return err
+}
+
+func couponRedemptionsDown(ctx context.Context, db *mongo.Database) error {
+	redemptions := db.Collection("CouponRedemptions")
+	cursor, err := redemptions.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"redeemed_at": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$coupon_id",
+			"redemptions": bson.M{"$push": bson.M{
+				"user_id":     "$user_id",
+				"order_id":    "$order_id",
+				"redeemed_at": "$redeemed_at",
+			}},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []bson.M
+	if err = cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	if len(groups) > 0 {
+		writes := make([]mongo.WriteModel, 0, len(groups))
+		for _, group := range groups {
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": group["_id"]}).
+				SetUpdate(bson.M{"$set": bson.M{"redemptions": group["redemptions"]}}))
+		}
+		if _, err = db.Collection("Coupons").BulkWrite(ctx, writes); err != nil {
+			return err
+		}
+	}
+	return redemptions.Drop(ctx)
+}
+
+// Prefix search reads the words of a product name from name_tokens, products
+// written before it existed get theirs here
+func productNameTokensUp(ctx context.Context, db *mongo.Database) error {
+	products := db.Collection("Products")
+	findOptions := options.Find().SetProjection(bson.M{"product_name": 1})
+	cursor, err := products.Find(ctx, bson.M{"product_name": bson.M{"$type": "string"}}, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	writes := make([]mongo.WriteModel, 0)
+	for cursor.Next(ctx) {
+		var product struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Name string             `bson:"product_name"`
+		}
+		if err = cursor.Decode(&product); err != nil {
+			return err
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": product.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"name_tokens": search.NameTokens(product.Name)}}))
+	}
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err = products.BulkWrite(ctx, writes)
+	return err
+}
+
+func productNameTokensDown(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("Products").UpdateMany(ctx, bson.M{"name_tokens": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"name_tokens": ""}})
+	return err
+}
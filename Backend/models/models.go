@@ -19,46 +19,274 @@ type User struct {
 	Updated_At      time.Time          `json:"updated_at"`
 	User_ID         string             `json:"user_id"`
 	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
+	Wishlist        []WishlistItem     `json:"wishlist" bson:"wishlist"`
 	Address_Details []Address          `json:"address" bson:"address"`
 	Order_Status    []Order            `'json:"orders" bson:"orders"`
+	// Set whenever the cart changes, abandoned cart reminders count from it
+	Cart_Updated_At *time.Time `json:"-" bson:"cart_updated_at,omitempty"`
+	// Reminders sent since the cart last changed
+	Cart_Reminders        int        `json:"-" bson:"cart_reminders"`
+	Cart_Reminded_At      *time.Time `json:"-" bson:"cart_reminded_at,omitempty"`
+	Cart_Reminder_Opt_Out bool       `json:"cart_reminder_opt_out" bson:"cart_reminder_opt_out"`
+	// Only set in the database, signup can't make anyone an admin
+	Role string `json:"-" bson:"role,omitempty"`
 }
 
+// RoleAdmin lets a user call the /admin routes
+const RoleAdmin = "admin"
+
 type Product struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name"`
-	Price        *uint64            `json:"price"`
-	Category     *string            `json:"category"`
-	Rating       *uint8             `json:"rating"`
-	Image        *string            `json:"image"`
+	Product_ID     primitive.ObjectID  `bson:"_id"`
+	SKU            *string             `json:"sku" bson:"sku,omitempty"`
+	Product_Name   *string             `json:"product_name"`
+	Price          *Money              `json:"price"`
+	Category       *string             `json:"category"`
+	Category_ID    *primitive.ObjectID `json:"category_id" bson:"category_id,omitempty"`
+	Rating         *float64            `json:"rating"` // average of the approved reviews
+	Review_Count   int                 `json:"review_count" bson:"review_count"`
+	Stock          *int                `json:"stock" bson:"stock,omitempty"` // nil when stock is not tracked
+	Image          *string             `json:"image"`                        // URL of the primary image
+	Images         []ProductImage      `json:"images" bson:"images,omitempty"`
+	Weight_Grams   *int                `json:"weight_grams"`
+	Display_Prices []Money             `json:"display_prices,omitempty" bson:"display_prices,omitempty"` // shown only, checkout charges Price
+	Name_Tokens    []string            `json:"-" bson:"name_tokens,omitempty"`                           // lower-cased words of the name for prefix search
+}
+
+// ProductImage is an uploaded image, the keys locate the files in storage
+type ProductImage struct {
+	Image_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	URL           string             `json:"url" bson:"url"`
+	Thumbnail_URL string             `json:"thumbnail_url" bson:"thumbnail_url"`
+	Key           string             `json:"-" bson:"key"`
+	Thumbnail_Key string             `json:"-" bson:"thumbnail_key"`
+	Content_Type  string             `json:"content_type" bson:"content_type"`
+	Size          int                `json:"size" bson:"size"`
+	Width         int                `json:"width" bson:"width"`
+	Height        int                `json:"height" bson:"height"`
+	Uploaded_At   time.Time          `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+// ProductPage is one page of the product listing, pass Next_Cursor back to get the next one
+type ProductPage struct {
+	Items       []Product `json:"items"`
+	Next_Cursor string    `json:"next_cursor"`
+	Total       int64     `json:"total"`
 }
 
 type ProductUser struct {
 	Product_ID   primitive.ObjectID `bson:"_id"`
 	Product_Name *string            `bson:"product_name"`
-	Price        int                `bson:"price"`
+	Price        Money              `bson:"price"`
 	Category     *string            `bson:"category"`
-	Rating       *uint              `bson:"rating"`
+	Rating       *float64           `bson:"rating"`
 	Image        *string            `bson:"image"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	Weight_Grams int                `json:"weight_grams" bson:"weight_grams"`
+}
+
+// GuestCart holds an anonymous shopper's cart until they sign up or log in,
+// carts left alone expire
+type GuestCart struct {
+	Token      string        `json:"token" bson:"_id"`
+	UserCart   []ProductUser `json:"usercart" bson:"usercart"`
+	Created_At time.Time     `json:"created_at" bson:"created_at"`
+	Updated_At time.Time     `json:"updated_at" bson:"updated_at"`
+}
+
+// WishlistItem remembers the price the customer saw, a price drop notification
+// goes out when the product gets cheaper than that
+type WishlistItem struct {
+	Product_ID   primitive.ObjectID `json:"product_id" bson:"_id"`
+	Product_Name *string            `json:"product_name" bson:"product_name"`
+	Image        *string            `json:"image" bson:"image"`
+	Price        Money              `json:"price" bson:"price"`
+	Added_At     time.Time          `json:"added_at" bson:"added_at"`
 }
 
 type Address struct {
-	Address_ID primitive.ObjectID `bson:"_id"`
-	House      *string            `json:"house" bson:"house"`
-	Street     *string            `json:"street" bson:"street"`
-	City       *string            `json:"city" bson:"city"`
-	Pincode    *string            `json:"pincode" bson:"pincode"`
+	Address_ID     primitive.ObjectID `bson:"_id"`
+	Label          *string            `json:"label" bson:"label,omitempty"` // home, work or anything the user likes
+	Recipient_Name *string            `json:"recipient_name" bson:"recipient_name,omitempty"`
+	Phone          *string            `json:"phone" bson:"phone,omitempty"`
+	House          *string            `json:"house" bson:"house"`
+	Street         *string            `json:"street" bson:"street"`
+	City           *string            `json:"city" bson:"city"`
+	State          *string            `json:"state" bson:"state,omitempty"`
+	Pincode        *string            `json:"pincode" bson:"pincode"`
+	Country        *string            `json:"country" bson:"country,omitempty"` // ISO 3166 code, addresses from before it was stored are in the default country
+	// From the pincode dataset when the address was saved, nil for pincodes it doesn't know
+	Serviceable *bool `json:"serviceable" bson:"serviceable,omitempty"`
+	// At most one address of the book has each flag, the first address gets both
+	Default_Shipping bool `json:"default_shipping" bson:"default_shipping"`
+	Default_Billing  bool `json:"default_billing" bson:"default_billing"`
 }
 
 type Order struct {
-	Order_ID       primitive.ObjectID `bson:"_id"`
-	Order_Cart     []ProductUser      `json:"order_cart" bson:"order_cart"`
-	Ordered_At     time.Time          `json:"order_at" bson:"order_at"`
-	Price          int                `json:"price" bson:"price"`
-	Discount       *int               `json:"discount" bson:"discount"`
-	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
+	Order_ID         primitive.ObjectID `bson:"_id"`
+	Order_Cart       []ProductUser      `json:"order_cart" bson:"order_cart"`
+	Ordered_At       time.Time          `json:"order_at" bson:"order_at"`
+	Subtotal         Money              `json:"subtotal" bson:"subtotal"`
+	Tax              Money              `json:"tax" bson:"tax"`
+	Shipping         Money              `json:"shipping" bson:"shipping"`
+	Price            Money              `json:"price" bson:"price"` // grand total the customer pays
+	Discount         *Money             `json:"discount" bson:"discount"`
+	Coupon_Code      *string            `json:"coupon_code,omitempty" bson:"coupon_code,omitempty"`
+	Payment_Method   Payment            `json:"payment_method" bson:"payment_method"`
+	Shipping_Address *Address           `json:"shipping_address,omitempty" bson:"shipping_address,omitempty"` // snapshot taken at checkout
+	Status           string             `json:"status" bson:"status"`
+	Delivered_At     *time.Time         `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
 }
 
+const (
+	OrderStatusPlaced    = "placed"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+)
+
 type Payment struct {
-	Digital bool `bson:"digital"`
-	COD     bool `bson:"cod"`
+	Digital   bool                `bson:"digital"`
+	COD       bool                `bson:"cod"`
+	Intent_ID *primitive.ObjectID `json:"intent_id,omitempty" bson:"intent_id,omitempty"`
+}
+
+const (
+	PaymentStatusAuthorized = "authorized"
+	PaymentStatusCaptured   = "captured"
+	// Part of the captured amount went back, the rest can still be refunded
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusRefunded          = "refunded"
+	PaymentStatusFailed            = "failed"
+)
+
+type PaymentIntent struct {
+	Intent_ID    primitive.ObjectID `json:"_id" bson:"_id"`
+	Order_ID     primitive.ObjectID `json:"order_id" bson:"order_id"`
+	User_ID      string             `json:"user_id" bson:"user_id"`
+	Provider     string             `json:"provider" bson:"provider"`
+	Provider_Ref string             `json:"provider_ref" bson:"provider_ref"`
+	Amount       Money              `json:"amount" bson:"amount"`
+	Refunded     Money              `json:"refunded" bson:"refunded"`
+	Status       string             `json:"status" bson:"status"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// CartSummary is what the cart would cost if it was checked out right now
+type CartSummary struct {
+	Subtotal      Money   `json:"subtotal"`
+	Discount      Money   `json:"discount"`
+	Tax           Money   `json:"tax"`
+	Shipping      Money   `json:"shipping"`
+	Total         Money   `json:"total"`
+	Coupon_Code   *string `json:"coupon_code,omitempty"`
+	Free_Shipping bool    `json:"free_shipping"`
+}
+
+const (
+	CouponPercentage   = "percentage"
+	CouponFixed        = "fixed"
+	CouponFreeShipping = "free_shipping"
+)
+
+type Coupon struct {
+	Coupon_ID      primitive.ObjectID `json:"_id" bson:"_id"`
+	Code           *string            `json:"code" bson:"code" validate:"required,min=3,max=32,alphanum"`
+	Type           *string            `json:"type" bson:"type" validate:"required,oneof=percentage fixed free_shipping"`
+	Percent        int                `json:"percent" bson:"percent" validate:"gte=0,lte=100"`
+	Amount         *Money             `json:"amount" bson:"amount"`
+	Min_Cart_Value *Money             `json:"min_cart_value" bson:"min_cart_value"`
+	Categories     []string           `json:"categories" bson:"categories"`
+	Usage_Limit    int                `json:"usage_limit" bson:"usage_limit" validate:"gte=0"`
+	Per_User_Limit int                `json:"per_user_limit" bson:"per_user_limit" validate:"gte=0"`
+	Used_Count     int                `json:"used_count" bson:"used_count"`
+	Starts_At      *time.Time         `json:"starts_at" bson:"starts_at"`
+	Expires_At     *time.Time         `json:"expires_at" bson:"expires_at"`
+	Created_At     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// CouponRedemption is one use of a coupon, kept in its own collection so a
+// popular coupon doesn't grow without bound
+type CouponRedemption struct {
+	Redemption_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Coupon_ID     primitive.ObjectID `json:"coupon_id" bson:"coupon_id"`
+	User_ID       string             `json:"user_id" bson:"user_id"`
+	Order_ID      primitive.ObjectID `json:"order_id" bson:"order_id"`
+	Redeemed_At   time.Time          `json:"redeemed_at" bson:"redeemed_at"`
+}
+
+type Category struct {
+	Category_ID primitive.ObjectID   `json:"_id" bson:"_id"`
+	Name        *string              `json:"name" bson:"name" validate:"required,min=2,max=60"`
+	Slug        string               `json:"slug" bson:"slug"`
+	Parent_ID   *primitive.ObjectID  `json:"parent_id" bson:"parent_id"`
+	Ancestors   []primitive.ObjectID `json:"ancestors" bson:"ancestors"` // root first, parent last
+	Created_At  time.Time            `json:"created_at" bson:"created_at"`
+	Updated_At  time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
+
+// Review is left by a customer whose order with the product was delivered,
+// only approved reviews count towards the product rating
+type Review struct {
+	Review_ID       primitive.ObjectID `json:"_id" bson:"_id"`
+	Product_ID      primitive.ObjectID `json:"product_id" bson:"product_id"`
+	User_ID         string             `json:"user_id" bson:"user_id"`
+	Order_ID        primitive.ObjectID `json:"order_id" bson:"order_id"`
+	Author          string             `json:"author" bson:"author"`
+	Rating          int                `json:"rating" bson:"rating" validate:"required,gte=1,lte=5"`
+	Title           string             `json:"title" bson:"title" validate:"max=120"`
+	Text            string             `json:"text" bson:"text" validate:"max=5000"`
+	Status          string             `json:"status" bson:"status"`
+	Moderation_Note string             `json:"moderation_note,omitempty" bson:"moderation_note,omitempty"`
+	Created_At      time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At      time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+type ReviewPage struct {
+	Items       []Review `json:"items"`
+	Next_Cursor string   `json:"next_cursor"`
+}
+
+const (
+	ImportStatusQueued    = "queued"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// ImportJob tracks a bulk product import while the importer works through it
+type ImportJob struct {
+	Job_ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	Format         string             `json:"format" bson:"format"`
+	Status         string             `json:"status" bson:"status"`
+	Total_Rows     int                `json:"total_rows" bson:"total_rows"`
+	Processed_Rows int                `json:"processed_rows" bson:"processed_rows"`
+	Created_Count  int                `json:"created" bson:"created"`
+	Updated_Count  int                `json:"updated" bson:"updated"`
+	Failed_Count   int                `json:"failed" bson:"failed"`
+	Row_Errors     []ImportRowError   `json:"-" bson:"row_errors"`                    // served by the error report
+	Error          string             `json:"error,omitempty" bson:"error,omitempty"` // why the whole job failed
+	Created_By     string             `json:"created_by" bson:"created_by"`
+	Created_At     time.Time          `json:"created_at" bson:"created_at"`
+	Started_At     *time.Time         `json:"started_at" bson:"started_at"`
+	Finished_At    *time.Time         `json:"finished_at" bson:"finished_at"`
+	// Renewed while the job runs, a running job past it has been abandoned
+	Lease_Until *time.Time `json:"-" bson:"lease_until,omitempty"`
+}
+
+type ImportRowError struct {
+	Row   int    `json:"row" bson:"row"`
+	SKU   string `json:"sku" bson:"sku"`
+	Error string `json:"error" bson:"error"`
 }
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("cannot combine amounts in different currencies")
+	ErrMoneyOverflow    = errors.New("amount is too large")
+	ErrInvalidMoney     = errors.New("invalid amount")
+)
+
+// DefaultCurrency is used for amounts stored before prices carried a currency,
+// main overrides it from the CURRENCY env variable
+var DefaultCurrency = "INR"
+
+// Currencies that don't use two decimal places
+var minorUnitDigits = map[string]int{
+	"JPY": 0, "KRW": 0, "VND": 0, "CLP": 0, "ISK": 0,
+	"BHD": 3, "KWD": 3, "OMR": 3, "JOD": 3, "TND": 3,
+}
+
+// Money is an amount in the minor unit (paise, cents) of an ISO 4217 currency.
+// All arithmetic is checked, mixing currencies or overflowing returns an error.
+type Money struct {
+	Amount   int64  `json:"amount" bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// FromMajor converts a whole number of major units (rupees, dollars) to Money
+func FromMajor(major int64, currency string) (Money, error) {
+	m := NewMoney(major, currency)
+	return m.Mul(scale(m.Currency))
+}
+
+// ParseMoney reads a decimal string like "499.99" in the given currency
+func ParseMoney(value string, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	digits := minorDigits(currency)
+	whole, frac, _ := strings.Cut(strings.TrimSpace(value), ".")
+	if len(frac) > digits {
+		return Money{}, ErrInvalidMoney
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+
+	negative := strings.HasPrefix(whole, "-")
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidMoney
+	}
+	m, err := FromMajor(major, currency)
+	if err != nil || frac == "" {
+		return m, err
+	}
+	minor, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || minor < 0 {
+		return Money{}, ErrInvalidMoney
+	}
+	if negative {
+		minor = -minor
+	}
+	return m.Add(NewMoney(minor, currency))
+}
+
+func minorDigits(currency string) int {
+	if digits, ok := minorUnitDigits[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+func scale(currency string) int64 {
+	return int64(math.Pow10(minorDigits(currency)))
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// sameCurrency lets a zero Money without a currency act as the identity,
+// so totals can start from Money{}
+func (m Money) sameCurrency(o Money) (string, error) {
+	switch {
+	case m.Currency == o.Currency:
+		return m.Currency, nil
+	case m.Currency == "" && m.Amount == 0:
+		return o.Currency, nil
+	case o.Currency == "" && o.Amount == 0:
+		return m.Currency, nil
+	}
+	return "", ErrCurrencyMismatch
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.sameCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	sum := m.Amount + o.Amount
+	if (o.Amount > 0 && sum < m.Amount) || (o.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: sum, Currency: currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+func (m Money) Mul(n int64) (Money, error) {
+	return m.MulDiv(n, 1)
+}
+
+// MulDiv returns m * num / den truncated towards zero, without overflowing in between
+func (m Money) MulDiv(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, ErrInvalidMoney
+	}
+	result := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num))
+	result.Quo(result, big.NewInt(den))
+	if !result.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: result.Int64(), Currency: m.Currency}, nil
+}
+
+// Cmp returns -1, 0 or 1 when m is less than, equal to or greater than o
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := m.sameCurrency(o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Min returns the smaller of the two amounts
+func (m Money) Min(o Money) (Money, error) {
+	cmp, err := m.Cmp(o)
+	if err != nil {
+		return Money{}, err
+	}
+	if cmp > 0 {
+		return o, nil
+	}
+	return m, nil
+}
+
+func (m Money) String() string {
+	return m.Currency + " " + m.Decimal()
+}
+
+// Decimal is the amount in major units without the currency, "499.99", the
+// format ParseMoney reads back
+func (m Money) Decimal() string {
+	digits := minorDigits(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, amount)
+	}
+	unit := uint64(scale(m.Currency))
+	return fmt.Sprintf("%s%d.%0*d", sign, uint64(amount)/unit, digits, uint64(amount)%unit)
+}
+
+// UnmarshalJSON accepts {"amount": 49999, "currency": "INR"} and, for older
+// clients, a bare number of major units in the default currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "null" {
+		return nil
+	}
+	if !strings.HasPrefix(trimmed, "{") {
+		legacy, err := ParseMoney(trimmed, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = legacy
+		return nil
+	}
+
+	type moneyJSON Money
+	var value moneyJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Currency == "" {
+		value.Currency = DefaultCurrency
+	}
+	*m = NewMoney(value.Amount, value.Currency)
+	return nil
+}
+
+// UnmarshalBSONValue reads the embedded document form and also the plain
+// numbers prices were stored as before, which are whole major units
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.EmbeddedDocument {
+		type moneyBSON Money
+		var value moneyBSON
+		if err := bson.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		if value.Currency == "" {
+			value.Currency = DefaultCurrency
+		}
+		*m = Money(value)
+		return nil
+	}
+
+	raw := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+		return nil
+	case bsontype.Int32, bsontype.Int64:
+		major, _ := raw.AsInt64OK()
+		legacy, err := FromMajor(major, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = legacy
+		return nil
+	case bsontype.Double:
+		legacy, err := ParseMoney(strconv.FormatFloat(raw.Double(), 'f', minorDigits(DefaultCurrency), 64), DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = legacy
+		return nil
+	}
+	return fmt.Errorf("cannot decode %s into Money", t)
+}
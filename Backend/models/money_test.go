@@ -0,0 +1,119 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	inr := func(amount int64) Money { return NewMoney(amount, "INR") }
+	usd := func(amount int64) Money { return NewMoney(amount, "USD") }
+
+	tests := []struct {
+		name string
+		op   func() (Money, error)
+		want Money
+		err  error
+	}{
+		{"add to a zero total", func() (Money, error) { return Money{}.Add(usd(99)) }, usd(99), nil},
+		{"add currencies", func() (Money, error) { return inr(1).Add(usd(1)) }, Money{}, ErrCurrencyMismatch},
+		{"add overflow", func() (Money, error) { return inr(math.MaxInt64).Add(inr(1)) }, Money{}, ErrMoneyOverflow},
+		{"add negative overflow", func() (Money, error) { return inr(math.MinInt64).Add(inr(-1)) }, Money{}, ErrMoneyOverflow},
+		{"sub min int", func() (Money, error) { return inr(0).Sub(inr(math.MinInt64)) }, Money{}, ErrMoneyOverflow},
+		{"mul overflow", func() (Money, error) { return inr(math.MaxInt64 / 2).Mul(3) }, Money{}, ErrMoneyOverflow},
+		{"muldiv truncates", func() (Money, error) { return inr(1000).MulDiv(1, 3) }, inr(333), nil},
+		{"muldiv large intermediate", func() (Money, error) { return inr(math.MaxInt64).MulDiv(4, 8) }, inr(math.MaxInt64 / 2), nil},
+		{"muldiv by zero", func() (Money, error) { return inr(1).MulDiv(1, 0) }, Money{}, ErrInvalidMoney},
+		{"min currencies", func() (Money, error) { return inr(300).Min(usd(200)) }, Money{}, ErrCurrencyMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMoneyAndDecimal(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     Money
+		err      error
+		decimal  string
+	}{
+		{"499.9", "INR", NewMoney(49990, "INR"), nil, "499.90"},
+		{"-1.05", "USD", NewMoney(-105, "USD"), nil, "-1.05"},
+		{"1500", "JPY", NewMoney(1500, "JPY"), nil, "1500"},
+		{"1.234", "KWD", NewMoney(1234, "KWD"), nil, "1.234"},
+		{"1.999", "INR", Money{}, ErrInvalidMoney, ""},
+		{"1.5", "JPY", Money{}, ErrInvalidMoney, ""},
+		{"abc", "INR", Money{}, ErrInvalidMoney, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.currency, func(t *testing.T) {
+			got, err := ParseMoney(tt.value, tt.currency)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.err == nil && got.Decimal() != tt.decimal {
+				t.Errorf("decimal = %q, want %q", got.Decimal(), tt.decimal)
+			}
+		})
+	}
+}
+
+// Prices written before Money existed are plain numbers in major units
+func TestMoneyUnmarshalJSONLegacyNumber(t *testing.T) {
+	var got Money
+	if err := json.Unmarshal([]byte(`499.5`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := NewMoney(49950, DefaultCurrency); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := json.Unmarshal([]byte(`1.234`), &got); err == nil {
+		t.Errorf("1.234 decoded to %v, want an error", got)
+	}
+}
+
+func TestMoneyUnmarshalBSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		price interface{}
+		want  Money
+	}{
+		{"document without currency", bson.M{"amount": int64(100)}, NewMoney(100, DefaultCurrency)},
+		{"legacy int32", int32(499), NewMoney(49900, DefaultCurrency)},
+		{"legacy double", 499.99, NewMoney(49999, DefaultCurrency)},
+		{"null", nil, Money{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(bson.M{"price": tt.price})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded struct {
+				Price Money `bson:"price"`
+			}
+			if err = bson.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Price != tt.want {
+				t.Errorf("got %v, want %v", decoded.Price, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/broker"
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	CartReminderQueue = "cart_reminder"
+	// Users reminded per scan, the rest wait for the next one
+	cartReminderBatch = 500
+)
+
+// CartReminderConfig controls when abandoned carts get a reminder
+type CartReminderConfig struct {
+	// How long a cart sits untouched before a reminder, and between reminders
+	After         time.Duration
+	Max_Reminders int
+	Scan_Interval time.Duration
+	// Base URL of the API, the unsubscribe link in the email points at it
+	Public_URL string
+	// Signs unsubscribe links so they work without logging in
+	Secret string
+}
+
+// CartReminderConfigFromEnv reads CART_REMINDER_AFTER, CART_REMINDER_MAX,
+// CART_REMINDER_SCAN, PUBLIC_URL and UNSUBSCRIBE_SECRET
+func CartReminderConfigFromEnv() CartReminderConfig {
+	config := CartReminderConfig{
+		After:         envDuration("CART_REMINDER_AFTER", 24*time.Hour),
+		Max_Reminders: 2,
+		Scan_Interval: envDuration("CART_REMINDER_SCAN", 15*time.Minute),
+		Public_URL:    strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"),
+		Secret:        os.Getenv("UNSUBSCRIBE_SECRET"),
+	}
+	if value := os.Getenv("CART_REMINDER_MAX"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			log.Printf("Warning: CART_REMINDER_MAX must be a number, using %d", config.Max_Reminders)
+		} else {
+			config.Max_Reminders = n
+		}
+	}
+	if config.Public_URL == "" {
+		config.Public_URL = "http://localhost:8080"
+	}
+	if config.Secret == "" {
+		config.Secret = os.Getenv("SECRET_KEY")
+	}
+	return config
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: %s must be a duration like 24h, using %s", key, fallback)
+		return fallback
+	}
+	return d
+}
+
+type CartReminderItem struct {
+	Product_Name string `json:"product_name"`
+	Quantity     int    `json:"quantity"`
+	Price        string `json:"price"`
+}
+
+// CartReminderMessage is what the worker renders into the reminder email
+type CartReminderMessage struct {
+	Email           string             `json:"email"`
+	Name            string             `json:"name"`
+	Items           []CartReminderItem `json:"items"`
+	Total           string             `json:"total"`
+	Unsubscribe_URL string             `json:"unsubscribe_url"`
+}
+
+// CartReminder periodically emails users who left items in their cart
+type CartReminder struct {
+	users  *mongo.Collection
+	broker *broker.Client
+	config CartReminderConfig
+}
+
+func NewCartReminder(users *mongo.Collection, brokerClient *broker.Client, config CartReminderConfig) *CartReminder {
+	return &CartReminder{users: users, broker: brokerClient, config: config}
+}
+
+func (r *CartReminder) Run(ctx context.Context) {
+	if r.config.Max_Reminders == 0 {
+		return
+	}
+	ticker := time.NewTicker(r.config.Scan_Interval)
+	defer ticker.Stop()
+	for {
+		r.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep queues a reminder for every stale cart. Each user is claimed before
+// the reminder is queued, so instances sweeping at the same time don't both
+// send one, and handed back when the broker is down so the next scan retries.
+func (r *CartReminder) Sweep(ctx context.Context) {
+	sweepCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	before := time.Now().Add(-r.config.After)
+	users, err := database.StaleCarts(sweepCtx, r.users, before, r.config.Max_Reminders, cartReminderBatch)
+	if err != nil {
+		return
+	}
+	for _, user := range users {
+		if user.Email == nil || user.Cart_Updated_At == nil {
+			continue
+		}
+		claimed, err := database.ClaimCartReminder(sweepCtx, r.users, user, before, r.config.Max_Reminders)
+		if err != nil || !claimed {
+			continue
+		}
+		if err := r.broker.Publish(CartReminderQueue, r.message(user)); err != nil {
+			log.Println("cart reminder not queued:", err)
+			if err := database.ReleaseCartReminder(sweepCtx, r.users, user); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+func (r *CartReminder) message(user models.User) CartReminderMessage {
+	message := CartReminderMessage{
+		Email:           *user.Email,
+		Items:           make([]CartReminderItem, 0, len(user.UserCart)),
+		Unsubscribe_URL: r.UnsubscribeURL(user.ID),
+	}
+	if user.First_Name != nil {
+		message.Name = *user.First_Name
+	}
+
+	// A total across currencies means nothing, the email leaves it out then
+	var total *models.Money
+	totalValid := true
+	for _, item := range user.UserCart {
+		reminderItem := CartReminderItem{Quantity: item.Quantity, Price: item.Price.String()}
+		if item.Product_Name != nil {
+			reminderItem.Product_Name = *item.Product_Name
+		}
+		message.Items = append(message.Items, reminderItem)
+
+		line, err := item.Price.Mul(int64(item.Quantity))
+		if err != nil {
+			totalValid = false
+			continue
+		}
+		if total == nil {
+			total = &line
+			continue
+		}
+		sum, err := total.Add(line)
+		if err != nil {
+			totalValid = false
+			continue
+		}
+		total = &sum
+	}
+	if total != nil && totalValid {
+		message.Total = total.String()
+	}
+	return message
+}
+
+// UnsubscribeURL is the link in the email that turns reminders off
+func (r *CartReminder) UnsubscribeURL(userID primitive.ObjectID) string {
+	query := url.Values{"uid": {userID.Hex()}, "sig": {r.sign(userID.Hex())}}
+	return r.config.Public_URL + "/users/unsubscribe/cart-reminders?" + query.Encode()
+}
+
+// VerifyUnsubscribe checks the signature of an unsubscribe link
+func (r *CartReminder) VerifyUnsubscribe(userID string, signature string) bool {
+	return hmac.Equal([]byte(r.sign(userID)), []byte(signature))
+}
+
+func (r *CartReminder) sign(userID string) string {
+	mac := hmac.New(sha256.New, []byte(r.config.Secret))
+	mac.Write([]byte("cart-reminders:" + userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/broker"
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	PriceDropQueue = "price_drop"
+	// The sweep catches what the change stream missed, or replaces it when
+	// MongoDB is not running as a replica set
+	priceDropSweep = 30 * time.Minute
+)
+
+// PriceDropMessage is what the worker turns into an email
+type PriceDropMessage struct {
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Product_ID   string `json:"product_id"`
+	Product_Name string `json:"product_name"`
+	Old_Price    string `json:"old_price"`
+	New_Price    string `json:"new_price"`
+}
+
+// PriceDropWatcher queues a notification on the broker whenever a wishlisted
+// product becomes cheaper than the price its customer last saw
+type PriceDropWatcher struct {
+	products *mongo.Collection
+	users    *mongo.Collection
+	broker   *broker.Client
+}
+
+func NewPriceDropWatcher(products, users *mongo.Collection, brokerClient *broker.Client) *PriceDropWatcher {
+	return &PriceDropWatcher{products: products, users: users, broker: brokerClient}
+}
+
+func (w *PriceDropWatcher) Run(ctx context.Context) {
+	w.Sweep(ctx, nil)
+
+	changed := make(chan primitive.ObjectID, 64)
+	go w.watchPrices(ctx, changed)
+
+	ticker := time.NewTicker(priceDropSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Sweep(ctx, nil)
+		case productID := <-changed:
+			w.Sweep(ctx, &productID)
+		}
+	}
+}
+
+// watchPrices reports the products whose price was written
+func (w *PriceDropWatcher) watchPrices(ctx context.Context, changed chan<- primitive.ObjectID) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"$or": bson.A{
+		bson.M{"operationType": "replace"},
+		bson.M{"operationType": "update", "updateDescription.updatedFields.price": bson.M{"$exists": true}},
+	}}}}}
+	stream, err := w.products.Watch(ctx, pipeline)
+	if err != nil {
+		log.Println("Warning: product change stream unavailable, price drops are checked periodically:", err)
+		return
+	}
+	defer stream.Close(ctx)
+
+	for stream.Next(ctx) {
+		var event struct {
+			DocumentKey struct {
+				ID primitive.ObjectID `bson:"_id"`
+			} `bson:"documentKey"`
+		}
+		if err := stream.Decode(&event); err != nil {
+			log.Println(err)
+			continue
+		}
+		select {
+		case changed <- event.DocumentKey.ID:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		log.Println("Warning: product change stream stopped:", err)
+	}
+}
+
+// Sweep queues a notification for every price drop found, for one product or
+// all of them. Each drop is claimed before it is queued, so instances sweeping
+// at the same time don't both send it, and handed back when the broker is down
+// so the next sweep tries again.
+func (w *PriceDropWatcher) Sweep(ctx context.Context, productID *primitive.ObjectID) {
+	sweepCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	drops, err := database.FindPriceDrops(sweepCtx, w.users, w.products, productID)
+	if err != nil {
+		return
+	}
+	for _, drop := range drops {
+		claimed, err := database.ClaimPriceDrop(sweepCtx, w.users, drop)
+		if err != nil || !claimed {
+			continue
+		}
+		message := PriceDropMessage{
+			Email:        drop.Email,
+			Name:         drop.First_Name,
+			Product_ID:   drop.Product_ID.Hex(),
+			Product_Name: drop.Product_Name,
+			Old_Price:    drop.Old_Price.String(),
+			New_Price:    drop.New_Price.String(),
+		}
+		if err := w.broker.Publish(PriceDropQueue, message); err != nil {
+			log.Println("price drop not queued:", err)
+			if err := database.ReleasePriceDrop(sweepCtx, w.users, drop); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package payment
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+)
+
+var (
+	ErrDeclined         = errors.New("payment was declined")
+	ErrUnknownPayment   = errors.New("payment reference not found")
+	ErrInvalidState     = errors.New("payment is not in a valid state for this operation")
+	ErrRefundTooLarge   = errors.New("refund amount exceeds captured amount")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidPayload   = errors.New("invalid webhook payload")
+)
+
+// Webhook event types a provider can report back to us
+const (
+	EventAuthorized = "payment.authorized"
+	EventCaptured   = "payment.captured"
+	EventRefunded   = "payment.refunded"
+	EventFailed     = "payment.failed"
+)
+
+type WebhookEvent struct {
+	Type         string `json:"type"`
+	Provider_Ref string `json:"provider_ref"`
+	// For payment.refunded the total refunded so far, not just this refund
+	Amount models.Money `json:"amount"`
+}
+
+// Provider is implemented by every payment gateway the backend can talk to.
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, amount models.Money, reference string) (string, error)
+	Capture(ctx context.Context, providerRef string) error
+	Refund(ctx context.Context, providerRef string, amount models.Money) error
+	VerifyWebhook(payload []byte, signature string) (*WebhookEvent, error)
+}
@@ -0,0 +1,111 @@
+package postal
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Place is what the dataset knows about a postal code
+type Place struct {
+	Country     string `json:"country"`
+	Postal_Code string `json:"postal_code"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Serviceable bool   `json:"serviceable"`
+}
+
+// Directory looks postal codes up in a dataset held in memory
+type Directory struct {
+	places map[string]Place
+}
+
+func directoryKey(country, code string) string {
+	return country + "/" + code
+}
+
+// LoadCSV reads rows of country,postal_code,city,state,serviceable with a
+// header line. Rows that don't parse are skipped with a warning.
+func LoadCSV(r io.Reader) (*Directory, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"country", "postal_code", "city", "state", "serviceable"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.New("pincode dataset is missing the " + name + " column")
+		}
+	}
+
+	directory := &Directory{places: make(map[string]Place)}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			log.Printf("Warning: pincode dataset line %d skipped: %v", line, err)
+			continue
+		}
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		serviceable, err := strconv.ParseBool(field("serviceable"))
+		if err != nil {
+			log.Printf("Warning: pincode dataset line %d skipped: serviceable must be true or false", line)
+			continue
+		}
+		country := strings.ToUpper(field("country"))
+		place := Place{
+			Country:     country,
+			Postal_Code: NormalizePostalCode(country, field("postal_code")),
+			City:        field("city"),
+			State:       field("state"),
+			Serviceable: serviceable,
+		}
+		directory.places[directoryKey(place.Country, place.Postal_Code)] = place
+	}
+	return directory, nil
+}
+
+// LoadFile loads a CSV dataset, a missing file gives an empty directory so
+// the service runs without one and just doesn't auto-fill
+func LoadFile(path string) *Directory {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Println("Warning: no pincode dataset loaded:", err)
+		return &Directory{places: make(map[string]Place)}
+	}
+	defer file.Close()
+
+	directory, err := LoadCSV(file)
+	if err != nil {
+		log.Println("Warning: no pincode dataset loaded:", err)
+		return &Directory{places: make(map[string]Place)}
+	}
+	log.Printf("Loaded %d pincodes from %s", len(directory.places), path)
+	return directory
+}
+
+func (d *Directory) Lookup(country, code string) (Place, bool) {
+	country = strings.ToUpper(country)
+	place, ok := d.places[directoryKey(country, NormalizePostalCode(country, code))]
+	return place, ok
+}
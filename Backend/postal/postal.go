@@ -0,0 +1,98 @@
+package postal
+
+import (
+	"os"
+	"strings"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+)
+
+// Checker validates addresses against the rules of their country and fills in
+// what the pincode dataset knows
+type Checker struct {
+	Directory       *Directory
+	Default_Country string
+}
+
+// FromEnv reads the dataset from PINCODE_DATA (default data/pincodes.csv) and
+// the country of addresses that don't name one from DEFAULT_COUNTRY (default IN)
+func FromEnv() *Checker {
+	path := os.Getenv("PINCODE_DATA")
+	if path == "" {
+		path = "data/pincodes.csv"
+	}
+	country := strings.ToUpper(os.Getenv("DEFAULT_COUNTRY"))
+	if country == "" {
+		country = "IN"
+	}
+	return &Checker{Directory: LoadFile(path), Default_Country: country}
+}
+
+func (c *Checker) Lookup(country, code string) (Place, bool) {
+	if country == "" {
+		country = c.Default_Country
+	}
+	return c.Directory.Lookup(country, code)
+}
+
+// Check normalizes the address, fills city and state from the dataset when they
+// are empty and flags whether we ship there. Pincodes missing from the dataset
+// are accepted with the serviceable flag left unset.
+func (c *Checker) Check(address *models.Address) error {
+	problems := make(map[string]string)
+	trimmed := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return strings.TrimSpace(*s)
+	}
+
+	country := strings.ToUpper(trimmed(address.Country))
+	if country == "" {
+		country = c.Default_Country
+	}
+	address.Country = &country
+	if !countryCode.MatchString(country) {
+		problems["country"] = "must be a two letter country code"
+	}
+	rules := rulesFor(country)
+
+	if trimmed(address.Recipient_Name) == "" {
+		problems["recipient_name"] = "is required"
+	}
+	phone := normalizePhone(trimmed(address.Phone))
+	address.Phone = &phone
+	if !rules.Phone.MatchString(phone) {
+		problems["phone"] = "is not a valid phone number for " + country
+	}
+
+	code := NormalizePostalCode(country, trimmed(address.Pincode))
+	address.Pincode = &code
+	address.Serviceable = nil
+	if !rules.Postal_Code.MatchString(code) {
+		problems["pincode"] = "is not a valid postal code for " + country
+	} else if place, ok := c.Directory.Lookup(country, code); ok {
+		if trimmed(address.City) == "" {
+			address.City = &place.City
+		}
+		if trimmed(address.State) == "" {
+			address.State = &place.State
+		} else if !strings.EqualFold(trimmed(address.State), place.State) {
+			problems["state"] = code + " is in " + place.State
+		}
+		serviceable := place.Serviceable
+		address.Serviceable = &serviceable
+	}
+
+	if trimmed(address.City) == "" {
+		problems["city"] = "is required"
+	}
+	if rules.State_Required && trimmed(address.State) == "" {
+		problems["state"] = "is required"
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Fields: problems}
+	}
+	return nil
+}
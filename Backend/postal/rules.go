@@ -0,0 +1,79 @@
+package postal
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rules is what a valid address looks like in one country
+type Rules struct {
+	Postal_Code    *regexp.Regexp
+	Phone          *regexp.Regexp
+	State_Required bool
+}
+
+// Phones are matched after spaces and dashes are stripped
+var countryRules = map[string]Rules{
+	"IN": {
+		Postal_Code:    regexp.MustCompile(`^[1-9][0-9]{5}$`),
+		Phone:          regexp.MustCompile(`^(\+91|0)?[6-9][0-9]{9}$`),
+		State_Required: true,
+	},
+	"US": {
+		Postal_Code:    regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`),
+		Phone:          regexp.MustCompile(`^(\+1)?[2-9][0-9]{9}$`),
+		State_Required: true,
+	},
+	"GB": {
+		Postal_Code: regexp.MustCompile(`^[A-Z]{1,2}[0-9][A-Z0-9]? [0-9][A-Z]{2}$`),
+		Phone:       regexp.MustCompile(`^(\+44|0)[0-9]{9,10}$`),
+	},
+}
+
+// Countries without their own rules only get loose sanity checks
+var fallbackRules = Rules{
+	Postal_Code: regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`),
+	Phone:       regexp.MustCompile(`^\+?[0-9]{6,15}$`),
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+func rulesFor(country string) Rules {
+	if rules, ok := countryRules[country]; ok {
+		return rules
+	}
+	return fallbackRules
+}
+
+// ValidationError lists what is wrong with an address, by json field name
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, name+": "+e.Fields[name])
+	}
+	return "invalid address: " + strings.Join(messages, ", ")
+}
+
+// NormalizePostalCode uppercases the code and puts the space of UK postcodes
+// where it belongs, so "sw1a1aa" and "SW1A 1AA" are the same code
+func NormalizePostalCode(country string, code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), ""))
+	if country == "GB" && len(code) > 3 {
+		code = code[:len(code)-3] + " " + code[len(code)-3:]
+	}
+	return code
+}
+
+func normalizePhone(phone string) string {
+	return strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(phone)
+}
@@ -0,0 +1,120 @@
+package pricing
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+)
+
+// Pricer bundles the calculators checkout needs to turn a cart into an order total
+type Pricer struct {
+	Shipping ShippingCalculator
+	Tax      TaxCalculator
+}
+
+// Quantity treats cart items stored before quantities existed as a single unit
+func Quantity(item models.ProductUser) int {
+	if item.Quantity <= 0 {
+		return 1
+	}
+	return item.Quantity
+}
+
+func LineTotal(item models.ProductUser) (models.Money, error) {
+	return item.Price.Mul(int64(Quantity(item)))
+}
+
+// Subtotal adds up the cart lines, it fails if the items are priced in different currencies
+func Subtotal(items []models.ProductUser) (models.Money, error) {
+	var subtotal models.Money
+	for _, item := range items {
+		line, err := LineTotal(item)
+		if err != nil {
+			return models.Money{}, err
+		}
+		if subtotal, err = subtotal.Add(line); err != nil {
+			return models.Money{}, err
+		}
+	}
+	return subtotal, nil
+}
+
+// FromEnv builds the calculators from the environment. Amounts are decimals in
+// the default currency, e.g. "49.50".
+//
+//	SHIPPING_MODE        flat (default), weight or zone
+//	SHIPPING_FLAT_RATE   flat amount per order
+//	SHIPPING_FREE_ABOVE  subtotal at which flat shipping becomes free
+//	SHIPPING_BASE_RATE   weight mode base amount
+//	SHIPPING_PER_KG      weight mode amount per started kg
+//	SHIPPING_ZONES       zone mode pincode prefixes, "11:40,56:60"
+//	SHIPPING_DEFAULT     zone mode amount outside the zones, -1 to refuse
+//	TAX_RATES            percent per category, "electronics:18,books:5"
+//	TAX_DEFAULT_RATE     percent for every other category
+func FromEnv() Pricer {
+	var shipping ShippingCalculator
+	switch os.Getenv("SHIPPING_MODE") {
+	case "weight":
+		shipping = WeightBased{Base: envMoney("SHIPPING_BASE_RATE"), PerKg: envMoney("SHIPPING_PER_KG")}
+	case "zone":
+		zones := Zones{Rates: make(map[string]models.Money), Default: envMoney("SHIPPING_DEFAULT")}
+		for prefix, amount := range envPairs("SHIPPING_ZONES") {
+			zones.Rates[prefix] = parseMoney(amount, "SHIPPING_ZONES")
+		}
+		shipping = zones
+	default:
+		shipping = FlatRate{Amount: envMoney("SHIPPING_FLAT_RATE"), FreeAbove: envMoney("SHIPPING_FREE_ABOVE")}
+	}
+
+	tax := CategoryRates{Rates: make(map[string]int64), Default: basisPoints(os.Getenv("TAX_DEFAULT_RATE"))}
+	for category, percent := range envPairs("TAX_RATES") {
+		tax.Rates[strings.ToLower(category)] = basisPoints(percent)
+	}
+
+	return Pricer{Shipping: shipping, Tax: tax}
+}
+
+func envMoney(key string) models.Money {
+	return parseMoney(os.Getenv(key), key)
+}
+
+func parseMoney(value string, key string) models.Money {
+	if value == "" {
+		return models.Money{}
+	}
+	m, err := models.ParseMoney(value, models.DefaultCurrency)
+	if err != nil {
+		log.Printf("Warning: %s has an invalid amount %q, using 0", key, value)
+		return models.Money{}
+	}
+	return m
+}
+
+func basisPoints(percent string) int64 {
+	if percent == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(percent, 64)
+	if err != nil {
+		log.Printf("Warning: invalid tax rate %q, using 0", percent)
+		return 0
+	}
+	return int64(math.Round(f * 100))
+}
+
+// envPairs parses "key:value,key:value" lists
+func envPairs(key string) map[string]string {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			continue
+		}
+		pairs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return pairs
+}
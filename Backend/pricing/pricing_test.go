@@ -0,0 +1,91 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+)
+
+func inr(amount int64) models.Money {
+	return models.NewMoney(amount, "INR")
+}
+
+func item(price int64, quantity int, grams int, category string) models.ProductUser {
+	line := models.ProductUser{Price: inr(price), Quantity: quantity, Weight_Grams: grams}
+	if category != "" {
+		line.Category = &category
+	}
+	return line
+}
+
+func TestShipping(t *testing.T) {
+	zones := Zones{Rates: map[string]models.Money{"1": inr(5000), "11": inr(4000), "560": inr(2500)}, Default: inr(9000)}
+	closedZones := Zones{Rates: map[string]models.Money{"11": inr(4000)}, Default: inr(-1)}
+
+	tests := []struct {
+		name       string
+		calculator ShippingCalculator
+		items      []models.ProductUser
+		pincode    string
+		want       models.Money
+		err        error
+	}{
+		{"flat at free", FlatRate{Amount: inr(4900), FreeAbove: inr(50000)}, []models.ProductUser{item(25000, 2, 0, "")}, "", models.Money{}, nil},
+		{"weight rounds up started kg", WeightBased{Base: inr(3000), PerKg: inr(1000)}, []models.ProductUser{item(100, 3, 400, "")}, "", inr(5000), nil},
+		{"zone longest prefix", zones, nil, "110001", inr(4000), nil},
+		{"zone short prefix", zones, nil, "122001", inr(5000), nil},
+		{"zone not serviceable", closedZones, nil, "400001", models.Money{}, ErrNotServiceable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subtotal, err := Subtotal(tt.items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.calculator.Shipping(tt.items, subtotal, tt.pincode)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategoryRatesTax(t *testing.T) {
+	rates := CategoryRates{Rates: map[string]int64{"electronics": 1800, "books": 500}, Default: 1200}
+	books := func(item models.ProductUser) bool { return item.Category != nil && *item.Category == "Books" }
+
+	tests := []struct {
+		name     string
+		items    []models.ProductUser
+		discount Discount
+		want     models.Money
+	}{
+		{"rate per category", []models.ProductUser{item(10000, 1, 0, "Electronics"), item(10000, 2, 0, "books")}, Discount{}, inr(1800 + 1000)},
+		// 3000 off 10000 + 20000, each line takes its share
+		{"discount over every line", []models.ProductUser{item(10000, 1, 0, "electronics"), item(20000, 1, 0, "books")}, Discount{Amount: inr(3000)}, inr(1620 + 900)},
+		// Only the books line is covered, electronics is taxed in full
+		{"discount over covered lines", []models.ProductUser{item(10000, 1, 0, "electronics"), item(20000, 1, 0, "Books")}, Discount{Amount: inr(3000), Applies: books}, inr(1800 + 850)},
+		{"discount covering nothing", []models.ProductUser{item(10000, 1, 0, "electronics")}, Discount{Amount: inr(3000), Applies: books}, inr(1800)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rates.Tax(tt.items, tt.discount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtotalRejectsMixedCurrencies(t *testing.T) {
+	items := []models.ProductUser{item(100, 1, 0, ""), {Price: models.NewMoney(100, "USD"), Quantity: 1}}
+	if _, err := Subtotal(items); err != models.ErrCurrencyMismatch {
+		t.Errorf("err = %v, want %v", err, models.ErrCurrencyMismatch)
+	}
+}
@@ -0,0 +1,76 @@
+package pricing
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+)
+
+var ErrNotServiceable = errors.New("we don't ship to this pincode yet")
+
+// ShippingCalculator prices the delivery of a set of cart items to a pincode
+type ShippingCalculator interface {
+	Shipping(items []models.ProductUser, subtotal models.Money, pincode string) (models.Money, error)
+}
+
+// FlatRate charges the same amount for every order, orders at or above
+// FreeAbove ship for free when it is set
+type FlatRate struct {
+	Amount    models.Money
+	FreeAbove models.Money
+}
+
+func (f FlatRate) Shipping(items []models.ProductUser, subtotal models.Money, pincode string) (models.Money, error) {
+	if !f.FreeAbove.IsZero() {
+		cmp, err := subtotal.Cmp(f.FreeAbove)
+		if err != nil {
+			return models.Money{}, err
+		}
+		if cmp >= 0 {
+			return models.Money{}, nil
+		}
+	}
+	return f.Amount, nil
+}
+
+// WeightBased charges a base amount plus a rate for every started kilogram
+type WeightBased struct {
+	Base  models.Money
+	PerKg models.Money
+}
+
+func (w WeightBased) Shipping(items []models.ProductUser, subtotal models.Money, pincode string) (models.Money, error) {
+	var grams int64
+	for _, item := range items {
+		grams += int64(item.Weight_Grams) * int64(Quantity(item))
+	}
+	kgs := (grams + 999) / 1000
+	perKg, err := w.PerKg.Mul(kgs)
+	if err != nil {
+		return models.Money{}, err
+	}
+	return w.Base.Add(perKg)
+}
+
+// Zones prices shipping by pincode prefix, the longest matching prefix wins.
+// Pincodes outside every zone use Default, or are rejected when Default is negative.
+type Zones struct {
+	Rates   map[string]models.Money
+	Default models.Money
+}
+
+func (z Zones) Shipping(items []models.ProductUser, subtotal models.Money, pincode string) (models.Money, error) {
+	best := -1
+	rate := z.Default
+	for prefix, amount := range z.Rates {
+		if strings.HasPrefix(pincode, prefix) && len(prefix) > best {
+			best = len(prefix)
+			rate = amount
+		}
+	}
+	if rate.IsNegative() {
+		return models.Money{}, ErrNotServiceable
+	}
+	return rate, nil
+}
@@ -0,0 +1,84 @@
+package pricing
+
+import (
+	"strings"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+)
+
+// TaxCalculator works out the tax owed on the cart items once the discount is taken off
+type TaxCalculator interface {
+	Tax(items []models.ProductUser, discount Discount) (models.Money, error)
+}
+
+// Discount is taken off the lines it applies to before they are taxed. A nil
+// Applies means every line.
+type Discount struct {
+	Amount  models.Money
+	Applies func(item models.ProductUser) bool
+}
+
+func (d Discount) covers(item models.ProductUser) bool {
+	return d.Applies == nil || d.Applies(item)
+}
+
+// CategoryRates taxes every item at the rate of its category. Rates are in basis
+// points (1800 = 18%) so everything stays in integer arithmetic.
+type CategoryRates struct {
+	Rates   map[string]int64
+	Default int64
+}
+
+func (t CategoryRates) Tax(items []models.ProductUser, discount Discount) (models.Money, error) {
+	subtotal, err := Subtotal(items)
+	if err != nil || subtotal.IsZero() {
+		return subtotal, err
+	}
+
+	covered := make([]models.ProductUser, 0, len(items))
+	for _, item := range items {
+		if discount.covers(item) {
+			covered = append(covered, item)
+		}
+	}
+	eligible, err := Subtotal(covered)
+	if err != nil {
+		return models.Money{}, err
+	}
+
+	var tax models.Money
+	for _, item := range items {
+		line, err := LineTotal(item)
+		if err != nil {
+			return models.Money{}, err
+		}
+		taxable := line
+		// The discount is spread over the lines it applies to in proportion to their value
+		if discount.covers(item) && !eligible.IsZero() {
+			share, err := discount.Amount.MulDiv(line.Amount, eligible.Amount)
+			if err != nil {
+				return models.Money{}, err
+			}
+			if taxable, err = line.Sub(share); err != nil {
+				return models.Money{}, err
+			}
+		}
+		lineTax, err := taxable.MulDiv(t.rate(item.Category), 10000)
+		if err != nil {
+			return models.Money{}, err
+		}
+		if tax, err = tax.Add(lineTax); err != nil {
+			return models.Money{}, err
+		}
+	}
+	return tax, nil
+}
+
+func (t CategoryRates) rate(category *string) int64 {
+	if category != nil {
+		if rate, ok := t.Rates[strings.ToLower(*category)]; ok {
+			return rate
+		}
+	}
+	return t.Default
+}
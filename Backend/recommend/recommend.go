@@ -0,0 +1,64 @@
+package recommend
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultInterval = 6 * time.Hour
+
+// Builder recomputes the "also bought" recommendations from the orders on a
+// schedule. Orders change slowly enough that fresh-to-the-hour doesn't matter
+// and the handlers only ever read the precomputed collection.
+type Builder struct {
+	users           *mongo.Collection
+	recommendations *mongo.Collection
+	interval        time.Duration
+}
+
+func NewBuilder(users, recommendations *mongo.Collection, interval time.Duration) *Builder {
+	return &Builder{users: users, recommendations: recommendations, interval: interval}
+}
+
+// FromEnv reads the rebuild interval from RECOMMENDATIONS_INTERVAL, a duration like 6h
+func FromEnv(users, recommendations *mongo.Collection) *Builder {
+	interval := defaultInterval
+	if value := os.Getenv("RECOMMENDATIONS_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Printf("Warning: RECOMMENDATIONS_INTERVAL must be a duration like 6h, using %s", defaultInterval)
+		} else {
+			interval = d
+		}
+	}
+	return NewBuilder(users, recommendations, interval)
+}
+
+func (b *Builder) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		b.Build(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Builder) Build(ctx context.Context) {
+	buildCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	started := time.Now()
+	if err := database.BuildRecommendations(buildCtx, b.users, b.recommendations); err != nil {
+		return
+	}
+	log.Println("Recommendations rebuilt in", time.Since(started).Round(time.Millisecond))
+}
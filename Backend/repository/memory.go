@@ -0,0 +1,545 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserRepository keeps users in a map, for tests
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]models.User
+}
+
+func NewMemoryUserRepository(users ...models.User) *MemoryUserRepository {
+	r := &MemoryUserRepository{users: make(map[primitive.ObjectID]models.User)}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *MemoryUserRepository) FindByID(ctx context.Context, userID string) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, database.ErrUserIdIsNotValid
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if user.Email != nil && *user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (r *MemoryUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	_, err := r.FindByEmail(ctx, email)
+	return err == nil, nil
+}
+
+func (r *MemoryUserRepository) PhoneExists(ctx context.Context, phone string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if user.Phone != nil && *user.Phone == phone {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[user.ID]; ok {
+		return database.ErrCantUpdateUser
+	}
+	for _, other := range r.users {
+		if other.Email != nil && user.Email != nil && *other.Email == *user.Email {
+			return ErrEmailExists
+		}
+		if other.Phone != nil && user.Phone != nil && *other.Phone == *user.Phone {
+			return ErrPhoneExists
+		}
+	}
+	r.users[user.ID] = *user
+	return nil
+}
+
+func (r *MemoryUserRepository) UpdateTokens(ctx context.Context, userID string, token string, refreshToken string) error {
+	return r.update(userID, func(user *models.User) error {
+		user.Token = &token
+		user.Refresh_Token = &refreshToken
+		user.Updated_At = time.Now()
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) Cart(ctx context.Context, userID string) ([]models.ProductUser, error) {
+	user, err := r.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	cart := append(make([]models.ProductUser, 0, len(user.UserCart)), user.UserCart...)
+	return cart, nil
+}
+
+func (r *MemoryUserRepository) SetCartReminderOptOut(ctx context.Context, userID string, optOut bool) error {
+	return r.update(userID, func(user *models.User) error {
+		user.Cart_Reminder_Opt_Out = optOut
+		return nil
+	})
+}
+
+func (r *MemoryUserRepository) update(userID string, change func(user *models.User) error) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return database.ErrUserIdIsNotValid
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err = change(&user); err != nil {
+		return err
+	}
+	r.users[id] = user
+	return nil
+}
+
+// MemoryProductRepository keeps products in a map, for tests. Its list cursors
+// are plain offsets.
+type MemoryProductRepository struct {
+	mu       sync.RWMutex
+	products map[primitive.ObjectID]models.Product
+}
+
+func NewMemoryProductRepository(products ...models.Product) *MemoryProductRepository {
+	r := &MemoryProductRepository{products: make(map[primitive.ObjectID]models.Product)}
+	for _, product := range products {
+		r.products[product.Product_ID] = product
+	}
+	return r
+}
+
+func (r *MemoryProductRepository) FindByID(ctx context.Context, productID primitive.ObjectID) (*models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	product, ok := r.products[productID]
+	if !ok {
+		return nil, database.ErrCantFindProoduct
+	}
+	return &product, nil
+}
+
+func (r *MemoryProductRepository) Create(ctx context.Context, product *models.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.products[product.Product_ID]; ok {
+		return database.ErrCantUpdateProduct
+	}
+	r.products[product.Product_ID] = *product
+	return nil
+}
+
+func (r *MemoryProductRepository) List(ctx context.Context, query database.ProductListQuery) (*models.ProductPage, error) {
+	if query.Sort != "" && query.Sort != "newest" && query.Sort != "price" && query.Sort != "rating" && query.Sort != "reviews" {
+		return nil, database.ErrInvalidSort
+	}
+	if query.Limit <= 0 {
+		query.Limit = database.DefaultPageSize
+	}
+	if query.Limit > database.MaxPageSize {
+		query.Limit = database.MaxPageSize
+	}
+	offset := 0
+	if query.Cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(query.Cursor); err != nil || offset < 0 {
+			return nil, database.ErrInvalidCursor
+		}
+	}
+
+	r.mu.RLock()
+	matches := make([]models.Product, 0)
+	for _, product := range r.products {
+		if memoryProductMatches(product, query) {
+			matches = append(matches, product)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		less, equal := memoryProductLess(matches[i], matches[j], query.Sort)
+		if equal {
+			less = matches[i].Product_ID.Hex() < matches[j].Product_ID.Hex()
+		}
+		if query.Ascending {
+			return less
+		}
+		return !less
+	})
+
+	page := models.ProductPage{Items: make([]models.Product, 0), Total: int64(len(matches))}
+	if offset < len(matches) {
+		end := offset + query.Limit
+		if end < len(matches) {
+			page.Next_Cursor = strconv.Itoa(end)
+		} else {
+			end = len(matches)
+		}
+		page.Items = append(page.Items, matches[offset:end]...)
+	}
+	return &page, nil
+}
+
+// Each goes through the products in id order
+func (r *MemoryProductRepository) Each(ctx context.Context, fn func(product models.Product) error) error {
+	r.mu.RLock()
+	products := make([]models.Product, 0, len(r.products))
+	for _, product := range r.products {
+		products = append(products, product)
+	}
+	r.mu.RUnlock()
+	sort.Slice(products, func(i, j int) bool { return products[i].Product_ID.Hex() < products[j].Product_ID.Hex() })
+	for _, product := range products {
+		if err := fn(product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *MemoryProductRepository) AddImages(ctx context.Context, productID primitive.ObjectID, images []models.ProductImage) (*models.Product, error) {
+	return r.update(productID, func(product *models.Product) error {
+		product.Images = append(append([]models.ProductImage(nil), product.Images...), images...)
+		if (product.Image == nil || *product.Image == "") && len(images) > 0 {
+			product.Image = &images[0].URL
+		}
+		return nil
+	})
+}
+
+func (r *MemoryProductRepository) RemoveImage(ctx context.Context, productID, imageID primitive.ObjectID) (*models.ProductImage, error) {
+	var removed *models.ProductImage
+	_, err := r.update(productID, func(product *models.Product) error {
+		kept := make([]models.ProductImage, 0, len(product.Images))
+		for i := range product.Images {
+			if product.Images[i].Image_ID == imageID {
+				removed = &product.Images[i]
+			} else {
+				kept = append(kept, product.Images[i])
+			}
+		}
+		if removed == nil {
+			return database.ErrImageNotFound
+		}
+		if product.Image != nil && *product.Image == removed.URL {
+			product.Image = nil
+			if len(kept) > 0 {
+				product.Image = &kept[0].URL
+			}
+		}
+		product.Images = kept
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+func (r *MemoryProductRepository) SetPrimaryImage(ctx context.Context, productID, imageID primitive.ObjectID) (*models.Product, error) {
+	return r.update(productID, func(product *models.Product) error {
+		for _, image := range product.Images {
+			if image.Image_ID == imageID {
+				url := image.URL
+				product.Image = &url
+				return nil
+			}
+		}
+		return database.ErrImageNotFound
+	})
+}
+
+func (r *MemoryProductRepository) update(productID primitive.ObjectID, change func(product *models.Product) error) (*models.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	product, ok := r.products[productID]
+	if !ok {
+		return nil, database.ErrCantFindProoduct
+	}
+	if err := change(&product); err != nil {
+		return nil, err
+	}
+	r.products[productID] = product
+	return &product, nil
+}
+
+func memoryProductMatches(product models.Product, query database.ProductListQuery) bool {
+	if query.Category != "" && (product.Category == nil || *product.Category != query.Category) {
+		return false
+	}
+	if len(query.Category_IDs) > 0 {
+		found := false
+		for _, id := range query.Category_IDs {
+			if product.Category_ID != nil && *product.Category_ID == id {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, bound := range []struct {
+		limit *models.Money
+		below bool
+	}{{query.Min_Price, true}, {query.Max_Price, false}} {
+		if bound.limit == nil {
+			continue
+		}
+		if product.Price == nil {
+			return false
+		}
+		cmp, err := product.Price.Cmp(*bound.limit)
+		if err != nil || (bound.below && cmp < 0) || (!bound.below && cmp > 0) {
+			return false
+		}
+	}
+	if query.Min_Rating != nil && (product.Rating == nil || *product.Rating < *query.Min_Rating) {
+		return false
+	}
+	return true
+}
+
+// memoryProductLess compares on the sort field, missing values sort first like in MongoDB
+func memoryProductLess(a, b models.Product, field string) (less bool, equal bool) {
+	var x, y float64
+	var hasX, hasY bool
+	switch field {
+	case "price":
+		if a.Price != nil {
+			x, hasX = float64(a.Price.Amount), true
+		}
+		if b.Price != nil {
+			y, hasY = float64(b.Price.Amount), true
+		}
+	case "rating":
+		if a.Rating != nil {
+			x, hasX = *a.Rating, true
+		}
+		if b.Rating != nil {
+			y, hasY = *b.Rating, true
+		}
+	case "reviews":
+		x, hasX = float64(a.Review_Count), true
+		y, hasY = float64(b.Review_Count), true
+	default:
+		return false, true
+	}
+	if hasX != hasY {
+		return !hasX, false
+	}
+	return x < y, x == y
+}
+
+// MemoryOrderRepository works on the orders of the users in a MemoryUserRepository
+type MemoryOrderRepository struct {
+	users *MemoryUserRepository
+}
+
+func NewMemoryOrderRepository(users *MemoryUserRepository) *MemoryOrderRepository {
+	return &MemoryOrderRepository{users: users}
+}
+
+func (r *MemoryOrderRepository) ListByUser(ctx context.Context, userID string) ([]models.Order, error) {
+	user, err := r.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return newestFirst(user.Order_Status), nil
+}
+
+func (r *MemoryOrderRepository) UpdateStatus(ctx context.Context, orderID primitive.ObjectID, status string) error {
+	// Every status can be reached from none, so this only rejects unknown ones
+	if err := database.CheckOrderTransition("", status); err != nil {
+		return err
+	}
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+	for id, user := range r.users.users {
+		for i := range user.Order_Status {
+			order := &user.Order_Status[i]
+			if order.Order_ID != orderID {
+				continue
+			}
+			if err := database.CheckOrderTransition(order.Status, status); err != nil {
+				return err
+			}
+			order.Status = status
+			if status == models.OrderStatusDelivered {
+				now := time.Now()
+				order.Delivered_At = &now
+			}
+			r.users.users[id] = user
+			return nil
+		}
+	}
+	return database.ErrOrderNotFound
+}
+
+// MemoryAddressRepository works on the address books of the users in a
+// MemoryUserRepository
+type MemoryAddressRepository struct {
+	users *MemoryUserRepository
+}
+
+func NewMemoryAddressRepository(users *MemoryUserRepository) *MemoryAddressRepository {
+	return &MemoryAddressRepository{users: users}
+}
+
+func (r *MemoryAddressRepository) List(ctx context.Context, userID string) ([]models.Address, error) {
+	user, err := r.users.FindByID(ctx, userID)
+	if err == ErrUserNotFound {
+		return nil, database.ErrUserIdIsNotValid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(make([]models.Address, 0, len(user.Address_Details)), user.Address_Details...), nil
+}
+
+func (r *MemoryAddressRepository) Find(ctx context.Context, userID string, addressID primitive.ObjectID) (*models.Address, error) {
+	addresses, err := r.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addresses {
+		if addresses[i].Address_ID == addressID {
+			return &addresses[i], nil
+		}
+	}
+	return nil, database.ErrAddressNotFound
+}
+
+// Add gives the first address both defaults, later ones only the ones asked for
+func (r *MemoryAddressRepository) Add(ctx context.Context, userID string, address models.Address) (*models.Address, error) {
+	address.Address_ID = primitive.NewObjectID()
+	err := r.update(userID, func(user *models.User) error {
+		if len(user.Address_Details) >= database.MaxAddresses {
+			return database.ErrTooManyAddresses
+		}
+		first := len(user.Address_Details) == 0
+		user.Address_Details = append(user.Address_Details, address)
+		setDefaults(user.Address_Details, address.Address_ID, address.Default_Shipping || first, address.Default_Billing || first)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.Find(ctx, userID, address.Address_ID)
+}
+
+func (r *MemoryAddressRepository) Update(ctx context.Context, userID string, addressID primitive.ObjectID, changes models.Address) (*models.Address, error) {
+	err := r.update(userID, func(user *models.User) error {
+		for i := range user.Address_Details {
+			address := &user.Address_Details[i]
+			if address.Address_ID != addressID {
+				continue
+			}
+			for _, field := range []struct{ from, to **string }{
+				{&changes.Label, &address.Label},
+				{&changes.Recipient_Name, &address.Recipient_Name},
+				{&changes.Phone, &address.Phone},
+				{&changes.House, &address.House},
+				{&changes.Street, &address.Street},
+				{&changes.City, &address.City},
+				{&changes.State, &address.State},
+				{&changes.Pincode, &address.Pincode},
+				{&changes.Country, &address.Country},
+			} {
+				if *field.from != nil {
+					*field.to = *field.from
+				}
+			}
+			if changes.Pincode != nil {
+				address.Serviceable = changes.Serviceable
+			}
+			setDefaults(user.Address_Details, addressID, changes.Default_Shipping, changes.Default_Billing)
+			return nil
+		}
+		return database.ErrAddressNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.Find(ctx, userID, addressID)
+}
+
+// Delete hands the defaults of a deleted address to the first one left
+func (r *MemoryAddressRepository) Delete(ctx context.Context, userID string, addressID primitive.ObjectID) error {
+	return r.update(userID, func(user *models.User) error {
+		kept := make([]models.Address, 0, len(user.Address_Details))
+		var removed *models.Address
+		for i := range user.Address_Details {
+			if user.Address_Details[i].Address_ID == addressID {
+				removed = &user.Address_Details[i]
+			} else {
+				kept = append(kept, user.Address_Details[i])
+			}
+		}
+		if removed == nil {
+			return database.ErrAddressNotFound
+		}
+		if len(kept) > 0 {
+			setDefaults(kept, kept[0].Address_ID, removed.Default_Shipping, removed.Default_Billing)
+		}
+		user.Address_Details = kept
+		return nil
+	})
+}
+
+// update works on a copy of the address book, a failed change leaves it alone
+func (r *MemoryAddressRepository) update(userID string, change func(user *models.User) error) error {
+	err := r.users.update(userID, func(user *models.User) error {
+		user.Address_Details = append([]models.Address(nil), user.Address_Details...)
+		return change(user)
+	})
+	if err == ErrUserNotFound {
+		return database.ErrUserIdIsNotValid
+	}
+	return err
+}
+
+// setDefaults moves the flags that are set to the address with id
+func setDefaults(addresses []models.Address, id primitive.ObjectID, shipping, billing bool) {
+	for i := range addresses {
+		if shipping {
+			addresses[i].Default_Shipping = addresses[i].Address_ID == id
+		}
+		if billing {
+			addresses[i].Default_Billing = addresses[i].Address_ID == id
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/payment"
+	"github.com/Bhanubpsn/e-commerce-backend/pricing"
+	"github.com/Bhanubpsn/e-commerce-backend/search"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoUserRepository struct {
+	users *mongo.Collection
+}
+
+func NewMongoUserRepository(users *mongo.Collection) *MongoUserRepository {
+	return &MongoUserRepository{users: users}
+}
+
+func (r *MongoUserRepository) FindByID(ctx context.Context, userID string) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, database.ErrUserIdIsNotValid
+	}
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *MongoUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email": email})
+}
+
+func (r *MongoUserRepository) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	err := r.users.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, database.ErrCantGetItem
+	}
+	return &user, nil
+}
+
+func (r *MongoUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	return r.exists(ctx, bson.M{"email": email})
+}
+
+func (r *MongoUserRepository) PhoneExists(ctx context.Context, phone string) (bool, error) {
+	return r.exists(ctx, bson.M{"phone": phone})
+}
+
+func (r *MongoUserRepository) exists(ctx context.Context, filter bson.M) (bool, error) {
+	count, err := r.users.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		log.Println(err)
+		return false, database.ErrCantGetItem
+	}
+	return count > 0, nil
+}
+
+// Create relies on the unique email and phone indexes, a check before the
+// insert can't stop two signups racing through different instances
+func (r *MongoUserRepository) Create(ctx context.Context, user *models.User) error {
+	_, err := r.users.InsertOne(ctx, user)
+	if err == nil {
+		return nil
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code != 11000 {
+				continue
+			}
+			switch r.duplicateKey(e) {
+			case "email":
+				return ErrEmailExists
+			case "phone":
+				return ErrPhoneExists
+			}
+		}
+	}
+	log.Println(err)
+	return database.ErrCantUpdateUser
+}
+
+// duplicateKey is the first field of the unique index a duplicate key error
+// tripped over. The server reports the index keys, older servers only name the
+// index in the message and it is looked up in the index registry.
+func (r *MongoUserRepository) duplicateKey(e mongo.WriteError) string {
+	if pattern, ok := e.Raw.Lookup("keyPattern").DocumentOK(); ok {
+		if keys, err := pattern.Elements(); err == nil && len(keys) > 0 {
+			return keys[0].Key()
+		}
+	}
+	for _, spec := range database.Indexes {
+		if spec.Collection == r.users.Name() && spec.Unique && strings.Contains(e.Message, " index: "+spec.Name()+" ") {
+			return spec.Keys[0].Key
+		}
+	}
+	return ""
+}
+
+func (r *MongoUserRepository) UpdateTokens(ctx context.Context, userID string, token string, refreshToken string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return database.ErrUserIdIsNotValid
+	}
+	update := bson.M{"$set": bson.M{"token": token, "refresh_token": refreshToken, "updated_at": time.Now()}}
+	if _, err = r.users.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		log.Println(err)
+		return database.ErrCantUpdateUser
+	}
+	return nil
+}
+
+func (r *MongoUserRepository) Cart(ctx context.Context, userID string) ([]models.ProductUser, error) {
+	user, err := r.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.UserCart == nil {
+		user.UserCart = make([]models.ProductUser, 0)
+	}
+	return user.UserCart, nil
+}
+
+func (r *MongoUserRepository) SetCartReminderOptOut(ctx context.Context, userID string, optOut bool) error {
+	return database.SetCartReminderOptOut(ctx, r.users, userID, optOut)
+}
+
+type MongoProductRepository struct {
+	products *mongo.Collection
+}
+
+func NewMongoProductRepository(products *mongo.Collection) *MongoProductRepository {
+	return &MongoProductRepository{products: products}
+}
+
+func (r *MongoProductRepository) FindByID(ctx context.Context, productID primitive.ObjectID) (*models.Product, error) {
+	return database.FindProduct(ctx, r.products, productID)
+}
+
+func (r *MongoProductRepository) Create(ctx context.Context, product *models.Product) error {
+	if product.Product_Name != nil {
+		product.Name_Tokens = search.NameTokens(*product.Product_Name)
+	}
+	if _, err := r.products.InsertOne(ctx, product); err != nil {
+		log.Println(err)
+		return database.ErrCantUpdateProduct
+	}
+	return nil
+}
+
+func (r *MongoProductRepository) List(ctx context.Context, query database.ProductListQuery) (*models.ProductPage, error) {
+	return database.ListProducts(ctx, r.products, query)
+}
+
+func (r *MongoProductRepository) Each(ctx context.Context, fn func(product models.Product) error) error {
+	return database.EachProduct(ctx, r.products, fn)
+}
+
+func (r *MongoProductRepository) AddImages(ctx context.Context, productID primitive.ObjectID, images []models.ProductImage) (*models.Product, error) {
+	return database.AddProductImages(ctx, r.products, productID, images)
+}
+
+func (r *MongoProductRepository) RemoveImage(ctx context.Context, productID, imageID primitive.ObjectID) (*models.ProductImage, error) {
+	return database.RemoveProductImage(ctx, r.products, productID, imageID)
+}
+
+func (r *MongoProductRepository) SetPrimaryImage(ctx context.Context, productID, imageID primitive.ObjectID) (*models.Product, error) {
+	return database.SetPrimaryImage(ctx, r.products, productID, imageID)
+}
+
+type MongoOrderRepository struct {
+	users *mongo.Collection
+}
+
+func NewMongoOrderRepository(users *mongo.Collection) *MongoOrderRepository {
+	return &MongoOrderRepository{users: users}
+}
+
+func (r *MongoOrderRepository) ListByUser(ctx context.Context, userID string) ([]models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, database.ErrUserIdIsNotValid
+	}
+	var user models.User
+	findOptions := options.FindOne().SetProjection(bson.M{"orders": 1})
+	err = r.users.FindOne(ctx, bson.M{"_id": id}, findOptions).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, database.ErrCantGetItem
+	}
+	return newestFirst(user.Order_Status), nil
+}
+
+func (r *MongoOrderRepository) UpdateStatus(ctx context.Context, orderID primitive.ObjectID, status string) error {
+	return database.UpdateOrderStatus(ctx, r.users, orderID, status)
+}
+
+// newestFirst returns the orders most recent first, they are stored oldest first
+func newestFirst(orders []models.Order) []models.Order {
+	sorted := make([]models.Order, 0, len(orders))
+	for i := len(orders) - 1; i >= 0; i-- {
+		sorted = append(sorted, orders[i])
+	}
+	return sorted
+}
+
+// MongoCheckoutRepository charges through provider and books coupon
+// redemptions as it places orders
+type MongoCheckoutRepository struct {
+	products    *mongo.Collection
+	users       *mongo.Collection
+	payments    *mongo.Collection
+	coupons     *mongo.Collection
+	redemptions *mongo.Collection
+	provider    payment.Provider
+	pricer      pricing.Pricer
+}
+
+func NewMongoCheckoutRepository(products, users, payments, coupons, redemptions *mongo.Collection, provider payment.Provider, pricer pricing.Pricer) *MongoCheckoutRepository {
+	return &MongoCheckoutRepository{
+		products:    products,
+		users:       users,
+		payments:    payments,
+		coupons:     coupons,
+		redemptions: redemptions,
+		provider:    provider,
+		pricer:      pricer,
+	}
+}
+
+func (r *MongoCheckoutRepository) BuyCart(ctx context.Context, userID string, request database.CheckoutRequest) (*models.Order, error) {
+	return database.BuyItemFromCart(ctx, r.products, r.users, r.payments, r.coupons, r.redemptions, r.provider, r.pricer, userID, request)
+}
+
+func (r *MongoCheckoutRepository) BuyNow(ctx context.Context, productID primitive.ObjectID, userID string, quantity int, request database.CheckoutRequest) (*models.Order, error) {
+	return database.InstantBuy(ctx, r.products, r.users, r.payments, r.coupons, r.redemptions, r.provider, r.pricer, productID, userID, quantity, request)
+}
+
+type MongoCartRepository struct {
+	products *mongo.Collection
+	users    *mongo.Collection
+}
+
+func NewMongoCartRepository(products, users *mongo.Collection) *MongoCartRepository {
+	return &MongoCartRepository{products: products, users: users}
+}
+
+func (r *MongoCartRepository) Add(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) ([]models.ProductUser, error) {
+	return database.AddProductToCart(ctx, r.products, r.users, productID, userID, quantity)
+}
+
+func (r *MongoCartRepository) Remove(ctx context.Context, productID primitive.ObjectID, userID string) error {
+	return database.RemoveCartItem(ctx, r.products, r.users, productID, userID)
+}
+
+type MongoGuestCartRepository struct {
+	products   *mongo.Collection
+	guestCarts *mongo.Collection
+	users      *mongo.Collection
+}
+
+func NewMongoGuestCartRepository(products, guestCarts, users *mongo.Collection) *MongoGuestCartRepository {
+	return &MongoGuestCartRepository{products: products, guestCarts: guestCarts, users: users}
+}
+
+func (r *MongoGuestCartRepository) Find(ctx context.Context, token string) (*models.GuestCart, error) {
+	return database.FindGuestCart(ctx, r.guestCarts, token)
+}
+
+func (r *MongoGuestCartRepository) Add(ctx context.Context, productID primitive.ObjectID, token string, quantity int) error {
+	return database.AddProductToGuestCart(ctx, r.products, r.guestCarts, productID, token, quantity)
+}
+
+func (r *MongoGuestCartRepository) Remove(ctx context.Context, productID primitive.ObjectID, token string) error {
+	return database.RemoveGuestCartItem(ctx, r.guestCarts, productID, token)
+}
+
+func (r *MongoGuestCartRepository) Merge(ctx context.Context, token string, userID string) error {
+	return database.MergeGuestCart(ctx, r.products, r.guestCarts, r.users, token, userID)
+}
+
+type MongoWishlistRepository struct {
+	products *mongo.Collection
+	users    *mongo.Collection
+}
+
+func NewMongoWishlistRepository(products, users *mongo.Collection) *MongoWishlistRepository {
+	return &MongoWishlistRepository{products: products, users: users}
+}
+
+func (r *MongoWishlistRepository) List(ctx context.Context, userID string) ([]models.WishlistItem, error) {
+	return database.Wishlist(ctx, r.users, userID)
+}
+
+func (r *MongoWishlistRepository) Add(ctx context.Context, productID primitive.ObjectID, userID string) error {
+	return database.AddToWishlist(ctx, r.products, r.users, productID, userID)
+}
+
+func (r *MongoWishlistRepository) Remove(ctx context.Context, productID primitive.ObjectID, userID string) error {
+	return database.RemoveFromWishlist(ctx, r.users, productID, userID)
+}
+
+func (r *MongoWishlistRepository) MoveToCart(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error {
+	return database.MoveToCart(ctx, r.products, r.users, productID, userID, quantity)
+}
+
+func (r *MongoWishlistRepository) SaveForLater(ctx context.Context, productID primitive.ObjectID, userID string) error {
+	return database.SaveForLater(ctx, r.products, r.users, productID, userID)
+}
+
+// MongoAddressRepository works on the address books stored with the users
+type MongoAddressRepository struct {
+	users *mongo.Collection
+}
+
+func NewMongoAddressRepository(users *mongo.Collection) *MongoAddressRepository {
+	return &MongoAddressRepository{users: users}
+}
+
+func (r *MongoAddressRepository) List(ctx context.Context, userID string) ([]models.Address, error) {
+	return database.Addresses(ctx, r.users, userID)
+}
+
+func (r *MongoAddressRepository) Find(ctx context.Context, userID string, addressID primitive.ObjectID) (*models.Address, error) {
+	return database.FindAddress(ctx, r.users, userID, addressID)
+}
+
+func (r *MongoAddressRepository) Add(ctx context.Context, userID string, address models.Address) (*models.Address, error) {
+	return database.AddAddress(ctx, r.users, userID, address)
+}
+
+func (r *MongoAddressRepository) Update(ctx context.Context, userID string, addressID primitive.ObjectID, changes models.Address) (*models.Address, error) {
+	return database.UpdateAddress(ctx, r.users, userID, addressID, changes)
+}
+
+func (r *MongoAddressRepository) Delete(ctx context.Context, userID string, addressID primitive.ObjectID) error {
+	return database.DeleteAddress(ctx, r.users, userID, addressID)
+}
+
+type MongoCouponRepository struct {
+	coupons     *mongo.Collection
+	redemptions *mongo.Collection
+	users       *mongo.Collection
+	pricer      pricing.Pricer
+}
+
+func NewMongoCouponRepository(coupons, redemptions, users *mongo.Collection, pricer pricing.Pricer) *MongoCouponRepository {
+	return &MongoCouponRepository{coupons: coupons, redemptions: redemptions, users: users, pricer: pricer}
+}
+
+func (r *MongoCouponRepository) Create(ctx context.Context, coupon *models.Coupon) error {
+	return database.CreateCoupon(ctx, r.coupons, coupon)
+}
+
+func (r *MongoCouponRepository) List(ctx context.Context) ([]models.Coupon, error) {
+	return database.ListCoupons(ctx, r.coupons)
+}
+
+func (r *MongoCouponRepository) Delete(ctx context.Context, code string) error {
+	return database.DeleteCoupon(ctx, r.coupons, code)
+}
+
+func (r *MongoCouponRepository) Preview(ctx context.Context, userID string, code string) (*models.CartSummary, error) {
+	return database.PreviewCoupon(ctx, r.users, r.coupons, r.redemptions, r.pricer, userID, code)
+}
+
+// MongoCategoryRepository keeps the category names stored on products in step
+// when categories change
+type MongoCategoryRepository struct {
+	categories *mongo.Collection
+	products   *mongo.Collection
+}
+
+func NewMongoCategoryRepository(categories, products *mongo.Collection) *MongoCategoryRepository {
+	return &MongoCategoryRepository{categories: categories, products: products}
+}
+
+func (r *MongoCategoryRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Category, error) {
+	return database.FindCategory(ctx, r.categories, id)
+}
+
+func (r *MongoCategoryRepository) All(ctx context.Context) ([]models.Category, error) {
+	return database.AllCategories(ctx, r.categories)
+}
+
+func (r *MongoCategoryRepository) Tree(ctx context.Context) ([]*models.CategoryNode, error) {
+	return database.CategoryTree(ctx, r.categories)
+}
+
+func (r *MongoCategoryRepository) Subtree(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return database.CategoryAndDescendants(ctx, r.categories, id)
+}
+
+func (r *MongoCategoryRepository) Create(ctx context.Context, category *models.Category) error {
+	return database.CreateCategory(ctx, r.categories, category)
+}
+
+func (r *MongoCategoryRepository) Update(ctx context.Context, id primitive.ObjectID, changes database.CategoryChanges) (*models.Category, error) {
+	return database.UpdateCategory(ctx, r.categories, r.products, id, changes)
+}
+
+func (r *MongoCategoryRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return database.DeleteCategory(ctx, r.categories, r.products, id)
+}
+
+func (r *MongoCategoryRepository) AssignProduct(ctx context.Context, productID, categoryID primitive.ObjectID) error {
+	return database.AssignProductCategory(ctx, r.categories, r.products, productID, categoryID)
+}
+
+// MongoReviewRepository keeps product ratings in step with the approved reviews
+type MongoReviewRepository struct {
+	reviews  *mongo.Collection
+	users    *mongo.Collection
+	products *mongo.Collection
+}
+
+func NewMongoReviewRepository(reviews, users, products *mongo.Collection) *MongoReviewRepository {
+	return &MongoReviewRepository{reviews: reviews, users: users, products: products}
+}
+
+func (r *MongoReviewRepository) Create(ctx context.Context, review *models.Review) error {
+	return database.CreateReview(ctx, r.reviews, r.users, review)
+}
+
+func (r *MongoReviewRepository) List(ctx context.Context, query database.ReviewQuery) (*models.ReviewPage, error) {
+	return database.ListReviews(ctx, r.reviews, query)
+}
+
+func (r *MongoReviewRepository) Moderate(ctx context.Context, id primitive.ObjectID, status string, note string) (*models.Review, error) {
+	return database.ModerateReview(ctx, r.reviews, r.products, id, status, note)
+}
+
+func (r *MongoReviewRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return database.DeleteReview(ctx, r.reviews, r.products, id)
+}
+
+type MongoPaymentRepository struct {
+	payments *mongo.Collection
+	provider payment.Provider
+}
+
+func NewMongoPaymentRepository(payments *mongo.Collection, provider payment.Provider) *MongoPaymentRepository {
+	return &MongoPaymentRepository{payments: payments, provider: provider}
+}
+
+func (r *MongoPaymentRepository) Refund(ctx context.Context, intentID primitive.ObjectID, amount models.Money) (*models.PaymentIntent, error) {
+	return database.RefundPayment(ctx, r.payments, r.provider, intentID, amount)
+}
+
+func (r *MongoPaymentRepository) ApplyEvent(ctx context.Context, event *payment.WebhookEvent) error {
+	return database.ApplyPaymentEvent(ctx, r.payments, event)
+}
+
+type MongoImportJobRepository struct {
+	jobs *mongo.Collection
+}
+
+func NewMongoImportJobRepository(jobs *mongo.Collection) *MongoImportJobRepository {
+	return &MongoImportJobRepository{jobs: jobs}
+}
+
+func (r *MongoImportJobRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.ImportJob, error) {
+	return database.FindImportJob(ctx, r.jobs, id)
+}
+
+type MongoRecommendationRepository struct {
+	views           *mongo.Collection
+	recommendations *mongo.Collection
+	products        *mongo.Collection
+}
+
+func NewMongoRecommendationRepository(views, recommendations, products *mongo.Collection) *MongoRecommendationRepository {
+	return &MongoRecommendationRepository{views: views, recommendations: recommendations, products: products}
+}
+
+func (r *MongoRecommendationRepository) RecordView(ctx context.Context, userID string, productID primitive.ObjectID) error {
+	return database.RecordProductView(ctx, r.views, userID, productID)
+}
+
+func (r *MongoRecommendationRepository) RecentlyViewed(ctx context.Context, userID string, limit int) ([]models.Product, error) {
+	return database.RecentlyViewed(ctx, r.views, r.products, userID, limit)
+}
+
+func (r *MongoRecommendationRepository) AlsoBought(ctx context.Context, productID primitive.ObjectID, limit int) ([]models.Product, error) {
+	return database.Recommendations(ctx, r.recommendations, r.products, productID, limit)
+}
@@ -0,0 +1,139 @@
+// Package repository is how the handlers reach the data they work on. The Mongo
+// implementations are used by the server, the in-memory ones let handlers run
+// in tests without a database.
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Bhanubpsn/e-commerce-backend/database"
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"github.com/Bhanubpsn/e-commerce-backend/payment"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	// Create returns these when another user already has the email or phone
+	ErrEmailExists = errors.New("user email already exists")
+	ErrPhoneExists = errors.New("user phone already exists")
+)
+
+type UserRepository interface {
+	FindByID(ctx context.Context, userID string) (*models.User, error)
+	// FindByEmail returns ErrUserNotFound when no user has the email
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	EmailExists(ctx context.Context, email string) (bool, error)
+	PhoneExists(ctx context.Context, phone string) (bool, error)
+	Create(ctx context.Context, user *models.User) error
+	UpdateTokens(ctx context.Context, userID string, token string, refreshToken string) error
+	Cart(ctx context.Context, userID string) ([]models.ProductUser, error)
+	SetCartReminderOptOut(ctx context.Context, userID string, optOut bool) error
+}
+
+type ProductRepository interface {
+	// FindByID returns database.ErrCantFindProoduct for unknown ids
+	FindByID(ctx context.Context, productID primitive.ObjectID) (*models.Product, error)
+	Create(ctx context.Context, product *models.Product) error
+	List(ctx context.Context, query database.ProductListQuery) (*models.ProductPage, error)
+	// Each calls fn with every product until fn returns an error
+	Each(ctx context.Context, fn func(product models.Product) error) error
+	AddImages(ctx context.Context, productID primitive.ObjectID, images []models.ProductImage) (*models.Product, error)
+	// RemoveImage returns the removed image so its files can be deleted
+	RemoveImage(ctx context.Context, productID, imageID primitive.ObjectID) (*models.ProductImage, error)
+	SetPrimaryImage(ctx context.Context, productID, imageID primitive.ObjectID) (*models.Product, error)
+}
+
+// OrderRepository works on the orders, which are stored with their user
+type OrderRepository interface {
+	ListByUser(ctx context.Context, userID string) ([]models.Order, error)
+	UpdateStatus(ctx context.Context, orderID primitive.ObjectID, status string) error
+}
+
+// CheckoutRepository turns carts into paid orders
+type CheckoutRepository interface {
+	// BuyCart orders everything in the user's cart
+	BuyCart(ctx context.Context, userID string, request database.CheckoutRequest) (*models.Order, error)
+	// BuyNow orders a single product without touching the cart
+	BuyNow(ctx context.Context, productID primitive.ObjectID, userID string, quantity int, request database.CheckoutRequest) (*models.Order, error)
+}
+
+type CartRepository interface {
+	// Add returns the cart with the product added
+	Add(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) ([]models.ProductUser, error)
+	Remove(ctx context.Context, productID primitive.ObjectID, userID string) error
+}
+
+// GuestCartRepository works on the carts of shoppers who haven't logged in,
+// found by their cart token
+type GuestCartRepository interface {
+	Find(ctx context.Context, token string) (*models.GuestCart, error)
+	Add(ctx context.Context, productID primitive.ObjectID, token string, quantity int) error
+	Remove(ctx context.Context, productID primitive.ObjectID, token string) error
+	// Merge moves the guest cart into the user's cart once they log in
+	Merge(ctx context.Context, token string, userID string) error
+}
+
+type WishlistRepository interface {
+	List(ctx context.Context, userID string) ([]models.WishlistItem, error)
+	Add(ctx context.Context, productID primitive.ObjectID, userID string) error
+	Remove(ctx context.Context, productID primitive.ObjectID, userID string) error
+	MoveToCart(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error
+	SaveForLater(ctx context.Context, productID primitive.ObjectID, userID string) error
+}
+
+// AddressRepository works on a user's address book
+type AddressRepository interface {
+	List(ctx context.Context, userID string) ([]models.Address, error)
+	// Find returns database.ErrAddressNotFound when the user has no such address
+	Find(ctx context.Context, userID string, addressID primitive.ObjectID) (*models.Address, error)
+	Add(ctx context.Context, userID string, address models.Address) (*models.Address, error)
+	Update(ctx context.Context, userID string, addressID primitive.ObjectID, changes models.Address) (*models.Address, error)
+	Delete(ctx context.Context, userID string, addressID primitive.ObjectID) error
+}
+
+type CouponRepository interface {
+	Create(ctx context.Context, coupon *models.Coupon) error
+	List(ctx context.Context) ([]models.Coupon, error)
+	Delete(ctx context.Context, code string) error
+	// Preview prices the user's cart with the coupon without redeeming it
+	Preview(ctx context.Context, userID string, code string) (*models.CartSummary, error)
+}
+
+type CategoryRepository interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Category, error)
+	All(ctx context.Context) ([]models.Category, error)
+	Tree(ctx context.Context) ([]*models.CategoryNode, error)
+	// Subtree is the category followed by all of its descendants
+	Subtree(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error)
+	Create(ctx context.Context, category *models.Category) error
+	Update(ctx context.Context, id primitive.ObjectID, changes database.CategoryChanges) (*models.Category, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	AssignProduct(ctx context.Context, productID, categoryID primitive.ObjectID) error
+}
+
+type ReviewRepository interface {
+	Create(ctx context.Context, review *models.Review) error
+	List(ctx context.Context, query database.ReviewQuery) (*models.ReviewPage, error)
+	Moderate(ctx context.Context, id primitive.ObjectID, status string, note string) (*models.Review, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type PaymentRepository interface {
+	// Refund gives back amount, a zero amount refunds what is left
+	Refund(ctx context.Context, intentID primitive.ObjectID, amount models.Money) (*models.PaymentIntent, error)
+	ApplyEvent(ctx context.Context, event *payment.WebhookEvent) error
+}
+
+type ImportJobRepository interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.ImportJob, error)
+}
+
+// RecommendationRepository records product views and reads the products
+// recommended from views and orders
+type RecommendationRepository interface {
+	RecordView(ctx context.Context, userID string, productID primitive.ObjectID) error
+	RecentlyViewed(ctx context.Context, userID string, limit int) ([]models.Product, error)
+	AlsoBought(ctx context.Context, productID primitive.ObjectID, limit int) ([]models.Product, error)
+}
@@ -2,13 +2,25 @@ package routes
 
 import (
 	"github.com/Bhanubpsn/e-commerce-backend/controllers"
+	"github.com/Bhanubpsn/e-commerce-backend/middleware"
 	"github.com/gin-gonic/gin"
 )
 
-func UserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", controllers.Signup())
-	incomingRoutes.POST("/users/signin", controllers.Login())
-	incomingRoutes.POST("/admin/addproduct", controllers.ProductViewerAdmin())
-	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
-	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+// UserRoutes registers the routes that don't need a login. idempotent is the
+// Idempotency middleware, attached to the ones that change state.
+func UserRoutes(incomingRoutes *gin.Engine, app *controllers.Application, idempotent gin.HandlerFunc) {
+	incomingRoutes.POST("/users/signup", idempotent, app.Signup())
+	incomingRoutes.POST("/users/signin", app.Login())
+	incomingRoutes.GET("/users/productview", app.SearchProduct())
+	incomingRoutes.GET("/users/search", app.SearchProductByQuery())
+	incomingRoutes.GET("/users/search/suggest", app.SearchSuggest())
+	incomingRoutes.GET("/users/categories", app.ListCategories())
+	incomingRoutes.GET("/users/pincodes/:code", app.LookupPincode())
+	incomingRoutes.GET("/users/products/:id", middleware.OptionalAuthentication(), app.ProductDetail())
+	incomingRoutes.GET("/users/products/:id/reviews", app.ProductReviews())
+	incomingRoutes.GET("/users/products/:id/recommendations", app.ProductRecommendations())
+	incomingRoutes.GET("/users/unsubscribe/cart-reminders", app.UnsubscribeCartReminders())
+	incomingRoutes.GET("/guest/cart", app.GetGuestCart())
+	incomingRoutes.POST("/guest/cart/items", idempotent, app.AddGuestCartItem())
+	incomingRoutes.DELETE("/guest/cart/items/:id", idempotent, app.DeleteGuestCartItem())
 }
\ No newline at end of file
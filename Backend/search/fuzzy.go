@@ -0,0 +1,91 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenize lower-cases the text and splits it into words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// NameTokens are the distinct words of a product name as search matches them,
+// stored on the product so prefix search can use an index
+func NameTokens(name string) []string {
+	tokens := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, word := range tokenize(name) {
+		if _, ok := seen[word]; ok {
+			continue
+		}
+		seen[word] = struct{}{}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// maxEdits is how many typos a word of this length may contain
+func maxEdits(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	}
+	return 2
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment) distance,
+// a swapped pair of letters counts as a single typo
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+// correct replaces every word that isn't in the vocabulary with the closest word
+// that is. It reports false when nothing could be corrected.
+func correct(text string, vocabulary map[string]struct{}) (string, bool) {
+	words := tokenize(text)
+	changed := false
+	for i, word := range words {
+		if _, ok := vocabulary[word]; ok {
+			continue
+		}
+		limit := maxEdits(word)
+		best, bestDistance := "", limit+1
+		for candidate := range vocabulary {
+			if d := editDistance(word, candidate); d < bestDistance || (d == bestDistance && candidate < best) {
+				best, bestDistance = candidate, d
+			}
+		}
+		if best != "" && bestDistance <= limit {
+			words[i] = best
+			changed = true
+		}
+	}
+	return strings.Join(words, " "), changed
+}
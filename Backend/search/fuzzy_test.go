@@ -0,0 +1,63 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"phone", "hpone", 1}, // a swapped pair is one typo
+		{"laptop", "lpatpo", 2},
+		{"", "abc", 3},
+		{"café", "cafe", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := editDistance(tt.a, tt.b); got != tt.want {
+				t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := editDistance(tt.b, tt.a); got != tt.want {
+				t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorrect(t *testing.T) {
+	vocabulary := map[string]struct{}{}
+	for _, word := range []string{"wireless", "headphones", "phone", "case", "cat", "laptop", "stand"} {
+		vocabulary[word] = struct{}{}
+	}
+
+	tests := []struct {
+		text    string
+		want    string
+		changed bool
+	}{
+		{"wireless headphones", "wireless headphones", false},
+		{"Phnoe CASE", "phone case", true},
+		{"lpatop stnad", "laptop stand", true},
+		// Three letter words are too short to guess at
+		{"cst", "cst", false},
+		{"zzzzzz", "zzzzzz", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, changed := correct(tt.text, vocabulary)
+			if got != tt.want || changed != tt.changed {
+				t.Errorf("correct(%q) = %q, %v, want %q, %v", tt.text, got, changed, tt.want, tt.changed)
+			}
+		})
+	}
+}
+
+func TestNameTokensSplitsOnPunctuation(t *testing.T) {
+	got := NameTokens("USB-C to USB-C cable")
+	if want := []string{"usb", "c", "to", "cable"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NameTokens = %q, want %q", got, want)
+	}
+}
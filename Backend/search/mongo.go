@@ -0,0 +1,252 @@
+package search
+
+import (
+	"context"
+	"log"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultLimit      = 20
+	maxLimit          = 50
+	vocabularyRefresh = 5 * time.Minute
+)
+
+// MongoService searches the Products collection with its text index
+type MongoService struct {
+	collection *mongo.Collection
+	// Lower bounds of the price facet buckets in minor units, the last bucket is open ended
+	priceBoundaries []int64
+
+	vocabulary   map[string]struct{}
+	vocabularyAt time.Time
+	mu           sync.Mutex
+}
+
+func NewMongoService(collection *mongo.Collection) *MongoService {
+	service := &MongoService{collection: collection}
+	for _, major := range []int64{0, 500, 1000, 5000, 10000, 50000} {
+		bound, _ := models.FromMajor(major, models.DefaultCurrency)
+		service.priceBoundaries = append(service.priceBoundaries, bound.Amount)
+	}
+	return service
+}
+
+// Search ranks text matches by relevance, tops them up with products whose name
+// starts with the typed words, and retries with a spell corrected query when
+// nothing matched at all
+func (s *MongoService) Search(ctx context.Context, query Query) (*Result, error) {
+	if len(tokenize(query.Text)) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	if _, err := sortOrder(query.Sort); err != nil {
+		return nil, err
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultLimit
+	}
+	if query.Limit > maxLimit {
+		query.Limit = maxLimit
+	}
+
+	result, err := s.search(ctx, query)
+	if err != nil || len(result.Items) > 0 {
+		return result, err
+	}
+
+	corrected, ok := correct(query.Text, s.loadVocabulary(ctx))
+	if !ok {
+		return result, nil
+	}
+	query.Text = corrected
+	correctedResult, err := s.search(ctx, query)
+	if err != nil || len(correctedResult.Items) == 0 {
+		return result, err
+	}
+	correctedResult.Corrected_Query = corrected
+	return correctedResult, nil
+}
+
+// sortOrder is how hits are ordered, rating and reviews fall back to relevance on ties
+func sortOrder(sort string) (bson.D, error) {
+	switch sort {
+	case "", "relevance":
+		return bson.D{{Key: "score", Value: -1}, {Key: "_id", Value: 1}}, nil
+	case "rating":
+		return bson.D{{Key: "rating", Value: -1}, {Key: "review_count", Value: -1}, {Key: "score", Value: -1}, {Key: "_id", Value: 1}}, nil
+	case "reviews":
+		return bson.D{{Key: "review_count", Value: -1}, {Key: "score", Value: -1}, {Key: "_id", Value: 1}}, nil
+	}
+	return nil, ErrInvalidSort
+}
+
+func (s *MongoService) search(ctx context.Context, query Query) (*Result, error) {
+	order, _ := sortOrder(query.Sort)
+
+	categoryMatch := bson.M{}
+	if query.Category != "" {
+		categoryMatch["category"] = query.Category
+	}
+	if len(query.Category_IDs) > 0 {
+		categoryMatch["category_id"] = bson.M{"$in": query.Category_IDs}
+	}
+
+	// Category counts ignore the category filter so the client can show the other options
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$text": bson.M{"$search": query.Text}}}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+		{{Key: "$facet", Value: bson.M{
+			"hits": bson.A{
+				bson.M{"$match": categoryMatch},
+				bson.M{"$sort": order},
+				bson.M{"$limit": query.Limit},
+			},
+			"categories": bson.A{
+				bson.M{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"prices": bson.A{
+				bson.M{"$match": categoryMatch},
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$price.amount",
+					"boundaries": append(append([]int64{}, s.priceBoundaries...), math.MaxInt64),
+					"default":    "other",
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			},
+		}}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrSearchFailed
+	}
+	defer cursor.Close(ctx)
+
+	var facets []struct {
+		Hits       []Hit   `bson:"hits"`
+		Categories []Facet `bson:"categories"`
+		Prices     []struct {
+			Bound interface{} `bson:"_id"`
+			Count int64       `bson:"count"`
+		} `bson:"prices"`
+	}
+	if err = cursor.All(ctx, &facets); err != nil || len(facets) == 0 {
+		log.Println(err)
+		return nil, ErrSearchFailed
+	}
+
+	result := Result{
+		Items:         facets[0].Hits,
+		Categories:    facets[0].Categories,
+		Price_Buckets: make([]PriceBucket, 0),
+	}
+	for _, bucket := range facets[0].Prices {
+		bound, ok := bucket.Bound.(int64)
+		if !ok {
+			continue // prices stored before the Money type have no amount to bucket on
+		}
+		result.Price_Buckets = append(result.Price_Buckets, s.priceBucket(bound, bucket.Count))
+	}
+
+	if len(result.Items) < query.Limit {
+		prefixHits, err := s.prefixMatches(ctx, query, result.Items)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, prefixHits...)
+	}
+	return &result, nil
+}
+
+func (s *MongoService) priceBucket(bound int64, count int64) PriceBucket {
+	bucket := PriceBucket{Min: models.NewMoney(bound, models.DefaultCurrency), Count: count}
+	for _, next := range s.priceBoundaries {
+		if next > bound {
+			upper := models.NewMoney(next, models.DefaultCurrency)
+			bucket.Max = &upper
+			break
+		}
+	}
+	return bucket
+}
+
+// prefixMatches finds products where every typed word starts a word of the name,
+// which is what makes half typed queries like "iph" work. They rank after the
+// text matches and are not part of the facet counts. The words are matched
+// against the lower-cased name tokens with anchored regexes, which the index
+// on name_tokens can answer with a range scan.
+func (s *MongoService) prefixMatches(ctx context.Context, query Query, seen []Hit) ([]Hit, error) {
+	clauses := bson.A{}
+	for _, word := range tokenize(query.Text) {
+		clauses = append(clauses, bson.M{"name_tokens": bson.M{"$regex": "^" + regexp.QuoteMeta(word)}})
+	}
+	exclude := bson.A{}
+	for _, hit := range seen {
+		exclude = append(exclude, hit.Product_ID)
+	}
+	clauses = append(clauses, bson.M{"_id": bson.M{"$nin": exclude}})
+	if query.Category != "" {
+		clauses = append(clauses, bson.M{"category": query.Category})
+	}
+	if len(query.Category_IDs) > 0 {
+		clauses = append(clauses, bson.M{"category_id": bson.M{"$in": query.Category_IDs}})
+	}
+
+	// Prefix matches have no text score, sorting on it is a no-op
+	order, _ := sortOrder(query.Sort)
+	findOptions := options.Find().SetLimit(int64(query.Limit - len(seen))).SetSort(order)
+	cursor, err := s.collection.Find(ctx, bson.M{"$and": clauses}, findOptions)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrSearchFailed
+	}
+	defer cursor.Close(ctx)
+
+	hits := make([]Hit, 0)
+	if err = cursor.All(ctx, &hits); err != nil {
+		log.Println(err)
+		return nil, ErrSearchFailed
+	}
+	return hits, nil
+}
+
+// loadVocabulary returns every word used in product names, cached for a few
+// minutes. The lock only guards the swap, searches keep using the old
+// vocabulary while a new one is read.
+func (s *MongoService) loadVocabulary(ctx context.Context) map[string]struct{} {
+	s.mu.Lock()
+	current, loadedAt := s.vocabulary, s.vocabularyAt
+	s.mu.Unlock()
+	if current != nil && time.Since(loadedAt) < vocabularyRefresh {
+		return current
+	}
+
+	names, err := s.collection.Distinct(ctx, "product_name", bson.M{})
+	if err != nil {
+		log.Println(err)
+		return current
+	}
+	vocabulary := make(map[string]struct{})
+	for _, name := range names {
+		if text, ok := name.(string); ok {
+			for _, word := range tokenize(text) {
+				vocabulary[word] = struct{}{}
+			}
+		}
+	}
+	s.mu.Lock()
+	s.vocabulary = vocabulary
+	s.vocabularyAt = time.Now()
+	s.mu.Unlock()
+	return vocabulary
+}
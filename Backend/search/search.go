@@ -0,0 +1,55 @@
+package search
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Bhanubpsn/e-commerce-backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrEmptyQuery   = errors.New("search query is empty")
+	ErrSearchFailed = errors.New("search failed")
+	ErrInvalidSort  = errors.New("sort must be relevance, rating or reviews")
+)
+
+type Query struct {
+	Text     string
+	Category string
+	// Restricts results to these categories, the caller expands descendants
+	Category_IDs []primitive.ObjectID
+	Sort         string // relevance, rating or reviews
+	Limit        int
+}
+
+type Hit struct {
+	models.Product `bson:",inline"`
+	Score          float64 `json:"score" bson:"score"`
+}
+
+type Facet struct {
+	Value string `json:"value" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// PriceBucket counts the matches priced in [Min, Max), Max is nil for the last bucket
+type PriceBucket struct {
+	Min   models.Money  `json:"min"`
+	Max   *models.Money `json:"max"`
+	Count int64         `json:"count"`
+}
+
+type Result struct {
+	Items         []Hit         `json:"items"`
+	Categories    []Facet       `json:"categories"`
+	Price_Buckets []PriceBucket `json:"price_buckets"`
+	// Set when nothing matched as typed and the query was spell corrected
+	Corrected_Query string `json:"corrected_query,omitempty"`
+}
+
+// Service is what the handlers search through, so the Mongo implementation can
+// be swapped for a dedicated search engine without touching them
+type Service interface {
+	Search(ctx context.Context, query Query) (*Result, error)
+}
@@ -0,0 +1,256 @@
+package search
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	suggestRefresh  = 5 * time.Minute
+	suggestDebounce = 5 * time.Second
+	// Reconnects to the change stream back off from the first delay up to the refresh interval
+	watchRetry     = 5 * time.Second
+	maxSuggestions = topSuggestions
+	// Only the most searched words feed into the weights
+	popularTermLimit = 5000
+	// A search counts at most this many words, each at most this long, so one
+	// request can't flood the terms collection
+	maxRecordedWords = 5
+	maxTermLength    = 32
+)
+
+// Suggester completes what the shopper is typing into the search box
+type Suggester interface {
+	Suggest(prefix string, limit int) []Suggestion
+	RecordSearch(ctx context.Context, text string)
+}
+
+// TrieSuggester serves suggestions from an in-memory trie of product names and
+// categories. Suggestions are weighted by how often their words were searched.
+type TrieSuggester struct {
+	products *mongo.Collection
+	terms    *mongo.Collection
+
+	index *trie
+	mu    sync.RWMutex
+}
+
+func NewTrieSuggester(products, terms *mongo.Collection) *TrieSuggester {
+	return &TrieSuggester{products: products, terms: terms, index: buildTrie(nil)}
+}
+
+func (s *TrieSuggester) Suggest(prefix string, limit int) []Suggestion {
+	if limit <= 0 || limit > maxSuggestions {
+		limit = maxSuggestions
+	}
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+	return index.lookup(prefix, limit)
+}
+
+// RecordSearch counts the words of a search so popular ones rank higher next
+// refresh. Repeated, overlong and extra words are left out.
+func (s *TrieSuggester) RecordSearch(ctx context.Context, text string) {
+	writes := make([]mongo.WriteModel, 0, maxRecordedWords)
+	seen := make(map[string]struct{})
+	for _, word := range tokenize(text) {
+		if len(writes) == maxRecordedWords {
+			break
+		}
+		if _, ok := seen[word]; ok || len([]rune(word)) > maxTermLength {
+			continue
+		}
+		seen[word] = struct{}{}
+		update := bson.M{"$inc": bson.M{"count": 1}, "$set": bson.M{"last_searched_at": time.Now()}}
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": word}).SetUpdate(update).SetUpsert(true))
+	}
+	if len(writes) == 0 {
+		return
+	}
+	if _, err := s.terms.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		log.Println(err)
+	}
+}
+
+// Run builds the trie and keeps it fresh until ctx is cancelled. Product changes
+// are picked up from a change stream, with a periodic rebuild as a fallback.
+func (s *TrieSuggester) Run(ctx context.Context) {
+	s.Refresh(ctx)
+
+	changed := make(chan struct{}, 1)
+	go s.watchProducts(ctx, changed)
+
+	ticker := time.NewTicker(suggestRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Refresh(ctx)
+		case <-changed:
+			// Bulk edits fire many events, rebuild once they settle
+			if !wait(ctx, suggestDebounce) {
+				return
+			}
+			select {
+			case <-changed:
+			default:
+			}
+			s.Refresh(ctx)
+		}
+	}
+}
+
+// watchProducts signals changed for every product change. A stream that fails
+// is reopened after the last event it delivered, the periodic rebuild covers
+// the products while it is down.
+func (s *TrieSuggester) watchProducts(ctx context.Context, changed chan<- struct{}) {
+	var resumeToken bson.Raw
+	retry := watchRetry
+	for {
+		streamOptions := options.ChangeStream()
+		if resumeToken != nil {
+			streamOptions.SetResumeAfter(resumeToken)
+		}
+		stream, err := s.products.Watch(ctx, mongo.Pipeline{}, streamOptions)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("Warning: product change stream unavailable, suggestions refresh periodically:", err)
+		} else {
+			for stream.Next(ctx) {
+				resumeToken = stream.ResumeToken()
+				retry = watchRetry
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+			err = stream.Err()
+			stream.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("Warning: product change stream stopped:", err)
+		}
+
+		if !wait(ctx, retry) {
+			return
+		}
+		if retry *= 2; retry > suggestRefresh {
+			retry = suggestRefresh
+		}
+	}
+}
+
+// wait sleeps for d and reports false when ctx was cancelled first
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Refresh rebuilds the trie from the current products and search popularity
+func (s *TrieSuggester) Refresh(ctx context.Context) {
+	popularity, err := s.loadPopularity(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	findOptions := options.Find().SetProjection(bson.M{"product_name": 1, "category": 1})
+	cursor, err := s.products.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var suggestions []*Suggestion
+	categories := make(map[string]*Suggestion)
+	for cursor.Next(ctx) {
+		var product struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Name     string             `bson:"product_name"`
+			Category string             `bson:"category"`
+		}
+		if err := cursor.Decode(&product); err != nil {
+			continue
+		}
+		if product.Name != "" {
+			suggestions = append(suggestions, &Suggestion{
+				Text:       product.Name,
+				Kind:       "product",
+				Product_ID: product.ID.Hex(),
+				Weight:     1 + weigh(product.Name, popularity),
+			})
+		}
+		if product.Category != "" {
+			key := strings.ToLower(product.Category)
+			if category, ok := categories[key]; ok {
+				// Categories with more products are better completions
+				category.Weight++
+			} else {
+				categories[key] = &Suggestion{Text: product.Category, Kind: "category", Weight: 1 + weigh(product.Category, popularity)}
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println(err)
+		return
+	}
+	for _, category := range categories {
+		suggestions = append(suggestions, category)
+	}
+
+	index := buildTrie(suggestions)
+	s.mu.Lock()
+	s.index = index
+	s.mu.Unlock()
+}
+
+func (s *TrieSuggester) loadPopularity(ctx context.Context) (map[string]int64, error) {
+	findOptions := options.Find().SetSort(bson.M{"count": -1}).SetLimit(popularTermLimit)
+	cursor, err := s.terms.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var terms []struct {
+		Word  string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &terms); err != nil {
+		return nil, err
+	}
+	popularity := make(map[string]int64, len(terms))
+	for _, term := range terms {
+		popularity[term.Word] = term.Count
+	}
+	return popularity, nil
+}
+
+func weigh(text string, popularity map[string]int64) int64 {
+	var weight int64
+	for _, word := range tokenize(text) {
+		weight += popularity[word]
+	}
+	return weight
+}
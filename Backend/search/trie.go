@@ -0,0 +1,111 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// Suggestions kept on every trie node, lookups never have to walk the subtree
+const topSuggestions = 10
+
+type Suggestion struct {
+	Text       string `json:"text"`
+	Kind       string `json:"kind"` // product or category
+	Product_ID string `json:"product_id,omitempty"`
+	Weight     int64  `json:"-"`
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	entries  []*Suggestion // entries whose key ends here
+	top      []*Suggestion // best entries in this subtree, heaviest first
+}
+
+// trie is immutable once built, the suggester swaps in a fresh one on refresh
+type trie struct {
+	root *trieNode
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[rune]*trieNode)}
+}
+
+// buildTrie indexes every suggestion under each word of its text, so "pro"
+// finds "iPhone 15 Pro" as well as "Probiotics"
+func buildTrie(suggestions []*Suggestion) *trie {
+	t := &trie{root: newTrieNode()}
+	for _, suggestion := range suggestions {
+		words := tokenize(suggestion.Text)
+		for i := range words {
+			t.insert(strings.Join(words[i:], " "), suggestion)
+		}
+	}
+	t.root.computeTop()
+	return t
+}
+
+func (t *trie) insert(key string, suggestion *Suggestion) {
+	node := t.root
+	for _, r := range key {
+		child, ok := node.children[r]
+		if !ok {
+			child = newTrieNode()
+			node.children[r] = child
+		}
+		node = child
+	}
+	node.entries = append(node.entries, suggestion)
+}
+
+func (n *trieNode) computeTop() []*Suggestion {
+	candidates := append([]*Suggestion{}, n.entries...)
+	for _, child := range n.children {
+		candidates = append(candidates, child.computeTop()...)
+	}
+	n.top = rankSuggestions(candidates, topSuggestions)
+	return n.top
+}
+
+// rankSuggestions drops duplicates and keeps the heaviest
+func rankSuggestions(candidates []*Suggestion, limit int) []*Suggestion {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Weight != candidates[j].Weight {
+			return candidates[i].Weight > candidates[j].Weight
+		}
+		return candidates[i].Text < candidates[j].Text
+	})
+
+	seen := make(map[*Suggestion]bool)
+	ranked := make([]*Suggestion, 0, limit)
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		ranked = append(ranked, candidate)
+		if len(ranked) == limit {
+			break
+		}
+	}
+	return ranked
+}
+
+func (t *trie) lookup(prefix string, limit int) []Suggestion {
+	node := t.root
+	for _, r := range strings.Join(tokenize(prefix), " ") {
+		child, ok := node.children[r]
+		if !ok {
+			return []Suggestion{}
+		}
+		node = child
+	}
+
+	results := make([]Suggestion, 0, limit)
+	for _, suggestion := range node.top {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, *suggestion)
+	}
+	return results
+}
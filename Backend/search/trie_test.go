@@ -0,0 +1,52 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrieLookup(t *testing.T) {
+	index := buildTrie([]*Suggestion{
+		{Text: "iPhone 15 Pro", Kind: "product", Weight: 30},
+		{Text: "Probiotics", Kind: "product", Weight: 10},
+		{Text: "Phone Cases", Kind: "category", Weight: 20},
+		{Text: "Pro Stand", Kind: "product", Weight: 10},
+	})
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		{"PRO", 10, []string{"iPhone 15 Pro", "Pro Stand", "Probiotics"}},
+		{"pro", 1, []string{"iPhone 15 Pro"}},
+		{"15 pro", 10, []string{"iPhone 15 Pro"}},
+		{"pro s", 10, []string{"Pro Stand"}},
+		{"xyz", 10, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, suggestion := range index.lookup(tt.prefix, tt.limit) {
+				got = append(got, suggestion.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup(%q) = %q, want %q", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrieKeepsTopSuggestions(t *testing.T) {
+	suggestions := make([]*Suggestion, 0)
+	for weight := int64(1); weight <= topSuggestions+5; weight++ {
+		suggestions = append(suggestions, &Suggestion{Text: "phone " + string(rune('a'+weight)), Weight: weight})
+	}
+	results := buildTrie(suggestions).lookup("phone", 100)
+	if len(results) != topSuggestions {
+		t.Fatalf("got %d suggestions, want %d", len(results), topSuggestions)
+	}
+	if results[0].Weight != topSuggestions+5 {
+		t.Errorf("heaviest suggestion weighs %d, want %d", results[0].Weight, topSuggestions+5)
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore writes files under Dir, the router serves them back under URL_Prefix
+type LocalStore struct {
+	Dir        string
+	URL_Prefix string
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err = os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		log.Println(err)
+		return "", ErrStoreFailed
+	}
+
+	// Write next to the target and rename so readers never see half a file
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		log.Println(err)
+		return "", ErrStoreFailed
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		log.Println(err)
+		return "", ErrStoreFailed
+	}
+	if err = tmp.Close(); err != nil {
+		log.Println(err)
+		return "", ErrStoreFailed
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		log.Println(err)
+		return "", ErrStoreFailed
+	}
+	if err = os.Rename(tmp.Name(), target); err != nil {
+		log.Println(err)
+		return "", ErrStoreFailed
+	}
+	return strings.TrimRight(s.URL_Prefix, "/") + "/" + key, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+		return ErrStoreFailed
+	}
+	return nil
+}
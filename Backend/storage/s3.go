@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Store talks to any S3 compatible service, MinIO included. Requests use path
+// style addressing and are signed with AWS Signature Version 4.
+type S3Store struct {
+	Endpoint   string // e.g. http://localhost:9000
+	Bucket     string
+	Region     string // us-east-1 when empty, which is what MinIO expects
+	Access_Key string
+	Secret_Key string
+	// Where clients fetch objects from, defaults to Endpoint/Bucket
+	Public_URL string
+	Client     *http.Client
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	headers := map[string]string{"content-type": contentType}
+	if err = s.do(ctx, http.MethodPut, key, data, headers); err != nil {
+		return "", err
+	}
+
+	base := s.Public_URL
+	if base == "" {
+		base = strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket
+	}
+	return strings.TrimRight(base, "/") + "/" + key, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodDelete, key, nil, nil)
+}
+
+func (s *S3Store) do(ctx context.Context, method string, key string, body []byte, headers map[string]string) error {
+	endpoint, err := url.Parse(strings.TrimRight(s.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		log.Println("invalid S3 endpoint:", s.Endpoint)
+		return ErrStoreFailed
+	}
+	endpoint.Path = "/" + s.Bucket + "/" + key
+	endpoint.RawPath = escapePath(endpoint.Path)
+
+	request, err := http.NewRequestWithContext(ctx, method, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		log.Println(err)
+		return ErrStoreFailed
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	s.sign(request, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		log.Println(err)
+		return ErrStoreFailed
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		log.Printf("S3 %s %s failed with %d: %s", method, key, response.StatusCode, message)
+		return ErrStoreFailed
+	}
+	return nil
+}
+
+// sign adds the SigV4 Authorization header, every header already set on the
+// request is signed along with host and the x-amz ones
+func (s *S3Store) sign(request *http.Request, body []byte, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	names := []string{"host"}
+	values := map[string]string{"host": request.URL.Host}
+	for name := range request.Header {
+		lower := strings.ToLower(name)
+		names = append(names, lower)
+		values[lower] = strings.TrimSpace(request.Header.Get(name))
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.Secret_Key), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.Access_Key, scope, signedHeaders, signature))
+}
+
+// escapePath percent encodes everything but unreserved characters and slashes,
+// which is the encoding SigV4 signs
+func escapePath(p string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(p) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~', b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
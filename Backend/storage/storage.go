@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid storage key")
+	ErrStoreFailed = errors.New("could not store the file")
+)
+
+// Store keeps uploaded files somewhere they can be served from. Put returns the
+// URL clients should use to fetch the file.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FromEnv picks the backend with STORAGE_BACKEND, local unless set to s3
+func FromEnv() Store {
+	if os.Getenv("STORAGE_BACKEND") == "s3" {
+		store := &S3Store{
+			Endpoint:   os.Getenv("S3_ENDPOINT"),
+			Bucket:     os.Getenv("S3_BUCKET"),
+			Region:     os.Getenv("S3_REGION"),
+			Access_Key: os.Getenv("S3_ACCESS_KEY"),
+			Secret_Key: os.Getenv("S3_SECRET_KEY"),
+			Public_URL: os.Getenv("S3_PUBLIC_URL"),
+		}
+		if store.Endpoint == "" || store.Bucket == "" {
+			log.Println("Warning: S3_ENDPOINT and S3_BUCKET must be set for the s3 storage backend")
+		}
+		return store
+	}
+
+	dir := os.Getenv("STORAGE_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	prefix := os.Getenv("STORAGE_URL_PREFIX")
+	if prefix == "" {
+		prefix = "/media"
+	}
+	return &LocalStore{Dir: dir, URL_Prefix: prefix}
+}
+
+// cleanKey rejects keys that would escape the store, like "../secrets"
+func cleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != key || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
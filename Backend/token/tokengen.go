@@ -1,18 +1,11 @@
 package token
 
 import (
-	"context"
-	"log"
 	"os"
 	"time"
 
-	"github.com/Bhanubpsn/e-commerce-backend/database"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type SignedDetails struct {
@@ -20,20 +13,21 @@ type SignedDetails struct {
 	First_Name string
 	Last_Name  string
 	Uid        string
+	Role       string
 	jwt.StandardClaims
 }
 
 var _ = godotenv.Load()
-var UserData *mongo.Collection = database.UserData(database.Client, "Users")
 
 var SECRET_KEY = os.Getenv("SECRET_KEY")
 
-func TokenGenerator(email string, firstname string, lastname string, uid string) (signedtoken string, signedrefreshtoken string, err error) {
+func TokenGenerator(email string, firstname string, lastname string, uid string, role string) (signedtoken string, signedrefreshtoken string, err error) {
 	claims := &SignedDetails{
 		Email:      email,
 		First_Name: firstname,
 		Last_Name:  lastname,
 		Uid:        uid,
+		Role:       role,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
 		},
@@ -80,27 +74,3 @@ func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
 	}
 	return claims, msg
 }
-
-func UpdateAllTokens(signedtoken string, signedrefreshtoken string, userid string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	var updateobj primitive.D
-	updateobj = append(updateobj, bson.E{Key: "token", Value: signedtoken})
-	updateobj = append(updateobj, bson.E{Key: "refresh_token", Value: signedrefreshtoken})
-	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateobj = append(updateobj, bson.E{Key: "updatedat", Value: updated_at})
-
-	upsert := true
-	filter := bson.M{"user_id": userid}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-	_, err := UserData.UpdateOne(ctx, filter, bson.D{
-		{Key: "$set", Value: updateobj},
-	}, &opt)
-
-	defer cancel()
-	if err != nil {
-		log.Panic(err)
-		return
-	}
-}
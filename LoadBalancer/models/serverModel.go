@@ -44,7 +44,7 @@ func (s *SimpleServer) IsAlive() bool {
 }
 
 func isServerResponsive(addr string) bool {
-	conn, err := http.Get(addr + "/users/productview") // Pinging a public route that doesn't require authentication
+	conn, err := http.Get(addr + "/users/productview?limit=1") // Pinging a public route that doesn't require authentication, one product is enough
 	if err != nil || conn.StatusCode != http.StatusOK {
 		return false
 	}
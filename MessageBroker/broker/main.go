@@ -6,30 +6,36 @@ import (
 	"log"
 	"net"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/joho/godotenv"
 )
 
+// Messages without a queue name go to the default queue, which is where the
+// signup emails have always been
+const defaultQueue = "default"
+
 type Broker struct {
-	queue []string
-	mu    sync.Mutex
+	queues map[string][]string
+	mu     sync.Mutex
 }
 
-func (b *Broker) Push(msg string) {
+func (b *Broker) Push(queue string, msg string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.queue = append(b.queue, msg)
+	b.queues[queue] = append(b.queues[queue], msg)
 }
 
-func (b *Broker) Pop() (string, bool) {
+func (b *Broker) Pop(queue string) (string, bool) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if len(b.queue) == 0 {
+	messages := b.queues[queue]
+	if len(messages) == 0 {
 		return "", false
 	}
-	msg := b.queue[0]
-	b.queue = b.queue[1:]
+	msg := messages[0]
+	b.queues[queue] = messages[1:]
 	return msg, true
 }
 
@@ -39,7 +45,7 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 	PORT := os.Getenv("PORT")
-	broker := &Broker{}
+	broker := &Broker{queues: make(map[string][]string)}
 	ln, _ := net.Listen("tcp", ":"+PORT)
 	fmt.Println("Custom Message Broker running on :" + PORT)
 
@@ -49,20 +55,42 @@ func main() {
 	}
 }
 
+// The protocol is one command per line:
+//
+//	POP                      pop from the default queue
+//	POP <queue>              pop from a named queue
+//	PUSH <queue> <payload>   push to a named queue
+//	<payload>                push to the default queue
+//
+// Pops answer with the message or EMPTY, pushes with ACK.
 func handleConnection(conn net.Conn, b *Broker) {
 	defer conn.Close()
 	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	for scanner.Scan() {
 		cmd := scanner.Text()
-		if cmd == "POP" {
-			if msg, ok := b.Pop(); ok {
+		switch {
+		case cmd == "POP" || strings.HasPrefix(cmd, "POP "):
+			queue := strings.TrimSpace(strings.TrimPrefix(cmd, "POP"))
+			if queue == "" {
+				queue = defaultQueue
+			}
+			if msg, ok := b.Pop(queue); ok {
 				fmt.Fprintln(conn, msg)
 			} else {
 				fmt.Fprintln(conn, "EMPTY")
 			}
-		} else {
+		case strings.HasPrefix(cmd, "PUSH "):
+			queue, payload, ok := strings.Cut(strings.TrimPrefix(cmd, "PUSH "), " ")
+			if !ok || queue == "" {
+				fmt.Fprintln(conn, "ERR usage: PUSH <queue> <payload>")
+				continue
+			}
+			b.Push(queue, payload)
+			fmt.Fprintln(conn, "ACK")
+		default:
 			// Assume any other text is a JSON payload to PUSH
-			b.Push(cmd)
+			b.Push(defaultQueue, cmd)
 			fmt.Fprintln(conn, "ACK")
 		}
 	}
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"mime"
 	"net"
 	"net/smtp"
 	"os"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -18,7 +21,53 @@ type Payload struct {
 	Name  string `json:"name"`
 }
 
-func SendEmail(to string, name string) error {
+// PriceDropPayload is queued by the backend when a wishlisted product gets cheaper
+type PriceDropPayload struct {
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	Product_Name string `json:"product_name"`
+	Old_Price    string `json:"old_price"`
+	New_Price    string `json:"new_price"`
+}
+
+// CartReminderPayload is queued by the backend for carts left without checking out
+type CartReminderPayload struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Items []struct {
+		Product_Name string `json:"product_name"`
+		Quantity     int    `json:"quantity"`
+		Price        string `json:"price"`
+	} `json:"items"`
+	Total           string `json:"total"`
+	Unsubscribe_URL string `json:"unsubscribe_url"`
+}
+
+var cartReminderTemplate = template.Must(template.New("cart_reminder").Parse(`Hello {{.Name}},
+
+You left these items in your cart:
+{{range .Items}}
+  {{.Quantity}} x {{.Product_Name}} at {{.Price}}{{end}}
+{{if .Total}}
+Total: {{.Total}}
+{{end}}
+Come back and complete your order before they sell out.
+
+Don't want these reminders? Unsubscribe: {{.Unsubscribe_URL}}`))
+
+// headerValue keeps a subject on its own header line, product names come from
+// the catalog and a CR or LF in one would start a new header
+func headerValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, value)
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func sendMail(to string, subject string, body string) error {
 	from := os.Getenv("EMAIL")
 	password := os.Getenv("PASSWORD")
 
@@ -27,11 +76,8 @@ func SendEmail(to string, name string) error {
 
 	auth := smtp.PlainAuth("", from, password, smtpHost)
 
-	subject := "Welcome!"
-	body := fmt.Sprintf("Hello %s,\n\nWelcome to our service!", name)
-
 	message := []byte(
-		"Subject: " + subject + "\r\n" +
+		"Subject: " + headerValue(subject) + "\r\n" +
 			"\r\n" +
 			body + "\r\n")
 
@@ -49,10 +95,68 @@ func SendEmail(to string, name string) error {
 	}
 
 	fmt.Println("Email Sent Successfully")
-	log.Println("Email Sent to: ", to, name)
 	return nil
 }
 
+func SendEmail(to string, name string) error {
+	body := fmt.Sprintf("Hello %s,\n\nWelcome to our service!", name)
+	err := sendMail(to, "Welcome!", body)
+	if err == nil {
+		log.Println("Email Sent to: ", to, name)
+	}
+	return err
+}
+
+func SendPriceDropEmail(data PriceDropPayload) error {
+	subject := "Price drop: " + data.Product_Name
+	body := fmt.Sprintf("Hello %s,\n\n%s from your wishlist is now %s, down from %s.",
+		data.Name, data.Product_Name, data.New_Price, data.Old_Price)
+	err := sendMail(data.Email, subject, body)
+	if err == nil {
+		log.Println("Price drop email sent to: ", data.Email, data.Product_Name)
+	}
+	return err
+}
+
+func SendCartReminderEmail(data CartReminderPayload) error {
+	var body strings.Builder
+	if err := cartReminderTemplate.Execute(&body, data); err != nil {
+		log.Println("Error rendering cart reminder: ", err)
+		return err
+	}
+	err := sendMail(data.Email, "You left something in your cart", body.String())
+	if err == nil {
+		log.Println("Cart reminder sent to: ", data.Email)
+	}
+	return err
+}
+
+// pop asks the broker for the next message of a queue, an empty queue name
+// means the default queue the welcome emails go to
+func pop(port string, queue string) (string, bool) {
+	conn, err := net.Dial("tcp", "localhost:"+port)
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+	defer conn.Close()
+
+	if queue == "" {
+		fmt.Fprintln(conn, "POP")
+	} else {
+		fmt.Fprintln(conn, "POP "+queue)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	if scanner.Scan() {
+		msg := scanner.Text()
+		if msg != "EMPTY" {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
@@ -60,20 +164,36 @@ func main() {
 	}
 	PORT := os.Getenv("PORT")
 	for {
-		conn, _ := net.Dial("tcp", "localhost:"+PORT)
-		fmt.Fprintln(conn, "POP")
-
-		scanner := bufio.NewScanner(conn)
-		if scanner.Scan() {
-			msg := scanner.Text()
-			if msg != "EMPTY" {
-				var data Payload
-				json.Unmarshal([]byte(msg), &data)
+		if msg, ok := pop(PORT, ""); ok {
+			var data Payload
+			if err := json.Unmarshal([]byte(msg), &data); err != nil {
+				log.Printf("Worker: Skipping malformed welcome email message: %v", err)
+			} else {
 				log.Printf("Worker: Sending email to %s", data.Email)
 				SendEmail(data.Email, data.Name)
 			}
 		}
-		conn.Close()
+
+		if msg, ok := pop(PORT, "price_drop"); ok {
+			var data PriceDropPayload
+			if err := json.Unmarshal([]byte(msg), &data); err != nil {
+				log.Printf("Worker: Skipping malformed price drop message: %v", err)
+			} else {
+				log.Printf("Worker: Sending price drop email to %s", data.Email)
+				SendPriceDropEmail(data)
+			}
+		}
+
+		if msg, ok := pop(PORT, "cart_reminder"); ok {
+			var data CartReminderPayload
+			if err := json.Unmarshal([]byte(msg), &data); err != nil {
+				log.Printf("Worker: Skipping malformed cart reminder message: %v", err)
+			} else {
+				log.Printf("Worker: Sending cart reminder to %s", data.Email)
+				SendCartReminderEmail(data)
+			}
+		}
+
 		time.Sleep(1 * time.Second) // Poll every second
 	}
 }